@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+	"github.com/urfave/cli"
+	"golang.org/x/sync/errgroup"
+)
+
+// A single port to generate or update in batch mode. Exactly one of Package
+// (generate a new portfile) or Portname (update an existing portfile) must be
+// given.
+type BatchEntry struct {
+	Package  string `toml:"package"`
+	Portname string `toml:"portname"`
+	Version  string `toml:"version"`
+	// Directory of lockfile in repo; defaults to the module's directory
+	Dir string `toml:"dir"`
+	// Where to write the portfile. Required when generating; defaults to the
+	// existing portfile when updating.
+	Output string `toml:"output"`
+	// Portfile template to use instead of the built-in one (when generating)
+	// or the one derived from the existing portfile (when updating)
+	Template string `toml:"template"`
+	// Source of GitHub distfiles, as for --tarball-from
+	TarballFrom string `toml:"tarball_from"`
+	// Pattern matching a GitHub release asset, as for --release-asset
+	ReleaseAsset string `toml:"release_asset"`
+	// Commands to build and install, as for --cmd
+	Commands []string `toml:"commands"`
+	// Whether to set version variables with -ldflags, as for --version-ldflags
+	VersionLdflags bool `toml:"version_ldflags"`
+	// Whether to check for cgo use, as for --cgo
+	Cgo bool `toml:"cgo"`
+	// Whether to detect the license, as for --license
+	License bool `toml:"license"`
+	// Whether to list the licenses of vendored modules, as for --vendor-licenses
+	VendorLicenses bool `toml:"vendor_licenses"`
+	// Whether to query the forge for metadata, as for --metadata
+	Metadata bool `toml:"metadata"`
+	// Whether to suggest categories, as for --categories
+	Categories bool `toml:"categories"`
+	// Whether to list indirect dependencies separately, as for --group-indirect
+	GroupIndirect bool `toml:"group_indirect"`
+}
+
+type BatchManifest struct {
+	Ports []BatchEntry `toml:"port"`
+}
+
+func (entry *BatchEntry) name() string {
+	if entry.Portname != "" {
+		return entry.Portname
+	}
+	return entry.Package
+}
+
+func readManifest(path string) (BatchManifest, error) {
+	manifest := BatchManifest{}
+	_, err := toml.DecodeFile(path, &manifest)
+	if err != nil {
+		return manifest, err
+	}
+	// Paths in the manifest are relative to the manifest itself
+	base := filepath.Dir(path)
+	for i := range manifest.Ports {
+		entry := &manifest.Ports[i]
+		if entry.Output != "" && entry.Output != "-" && !filepath.IsAbs(entry.Output) {
+			entry.Output = filepath.Join(base, entry.Output)
+		}
+		if entry.Template != "" && !filepath.IsAbs(entry.Template) {
+			entry.Template = filepath.Join(base, entry.Template)
+		}
+	}
+	return manifest, nil
+}
+
+func (entry *BatchEntry) validate() error {
+	if (entry.Package == "") == (entry.Portname == "") {
+		return errors.New("Specify exactly one of package or portname")
+	}
+	if entry.Version == "" {
+		return errors.New("Version is required")
+	}
+	if entry.Package != "" && entry.Output == "" {
+		return errors.New("Output is required when generating a new portfile")
+	}
+	return checkTarballFrom(entry.TarballFrom)
+}
+
+func batch(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("Please specify a manifest file", 1)
+	}
+	manifest, err := readManifest(c.Args().Get(0))
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	// Downloads are limited globally by fetch, so no limit is needed here
+	var g errgroup.Group
+	outputs := make([][]byte, len(manifest.Ports))
+	errs := make([]error, len(manifest.Ports))
+
+	for i, entry := range manifest.Ports {
+		i, entry := i, entry
+		g.Go(func() error {
+			outputs[i], errs[i] = batchOne(entry)
+			return nil
+		})
+	}
+
+	_ = g.Wait()
+
+	// Portfiles written to stdout are printed in manifest order
+	for _, out := range outputs {
+		if _, err := fmt.Print(string(out)); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+	}
+
+	failed := 0
+	for i, entry := range manifest.Ports {
+		if errs[i] == nil {
+			log.Printf("%s: ok", entry.name())
+		} else {
+			log.Printf("%s: FAILED: %s", entry.name(), errs[i])
+			failed++
+		}
+	}
+	if failed > 0 {
+		msg := fmt.Sprintf("%d of %d ports failed", failed, len(manifest.Ports))
+		return cli.NewExitError(msg, 1)
+	}
+	return nil
+}
+
+// Generate or update the port of entry. Returns the portfile if its output
+// is "-", to be printed in order with the others.
+func batchOne(entry BatchEntry) ([]byte, error) {
+	if err := entry.validate(); err != nil {
+		return nil, err
+	}
+	opts := Options{
+		LockfileDir:    entry.Dir,
+		TarballFrom:    entry.TarballFrom,
+		ReleaseAsset:   entry.ReleaseAsset,
+		Commands:       entry.Commands,
+		VersionLdflags: entry.VersionLdflags,
+		Cgo:            entry.Cgo,
+		License:        entry.License,
+		VendorLicenses: entry.VendorLicenses,
+		Metadata:       entry.Metadata,
+		Categories:     entry.Categories,
+		GroupIndirect:  entry.GroupIndirect,
+	}
+
+	var pkg Package
+	var tmplate string
+	var err error
+	outfile := entry.Output
+	if entry.Package != "" {
+		pkg, err = newPackage(entry.Package, entry.Version)
+		if err != nil {
+			return nil, err
+		}
+		tmplate = portfileTemplate
+	} else {
+		var portfilePath, portfileOld string
+		pkg, portfilePath, portfileOld, err = existingPortfile(entry.Portname, entry.Version)
+		if err != nil {
+			return nil, err
+		}
+		tmplate, err = templateFromPortfile(pkg, portfileOld)
+		if err != nil {
+			return nil, err
+		}
+		opts, err = optionsFromPortfile(opts, portfileOld)
+		if err != nil {
+			return nil, err
+		}
+		if outfile == "" {
+			outfile = portfilePath
+		}
+	}
+	if entry.Template != "" {
+		data, err := os.ReadFile(entry.Template)
+		if err != nil {
+			return nil, err
+		}
+		tmplate = string(data)
+	}
+
+	result, err := generateOne(pkg, tmplate, opts)
+	if err != nil {
+		return nil, err
+	}
+	if outfile == "-" {
+		return []byte(result.Portfile), nil
+	}
+	return nil, os.WriteFile(outfile, []byte(result.Portfile), 0755)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadManifest(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.toml")
+	err := os.WriteFile(path, []byte(`
+[[port]]
+package = "github.com/amake/go2port"
+version = "1.0.0"
+output = "out/Portfile"
+template = "/abs/Portfile.tmpl"
+
+[[port]]
+portname = "go2port"
+version = "1.0.1"
+output = "-"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := readManifest(path)
+	if err != nil {
+		t.Fatalf("readManifest failed: %v", err)
+	}
+	if len(manifest.Ports) != 2 {
+		t.Fatalf("expected 2 ports; got %d", len(manifest.Ports))
+	}
+	first := manifest.Ports[0]
+	if first.Output != filepath.Join(dir, "out/Portfile") {
+		t.Errorf("output not resolved relative to manifest: %s", first.Output)
+	}
+	if first.Template != "/abs/Portfile.tmpl" {
+		t.Errorf("absolute template path modified: %s", first.Template)
+	}
+	if err := first.validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+	second := manifest.Ports[1]
+	if second.Output != "-" {
+		t.Errorf("stdout output modified: %s", second.Output)
+	}
+	if second.name() != "go2port" {
+		t.Errorf("unexpected name: %s", second.name())
+	}
+}
+
+func TestBatchEntryValidate(t *testing.T) {
+	invalid := []BatchEntry{
+		{Version: "1.0.0"},
+		{Package: "github.com/foo/bar", Portname: "bar", Version: "1.0.0", Output: "x"},
+		{Portname: "bar"},
+		{Package: "github.com/foo/bar", Version: "1.0.0"},
+	}
+	for _, entry := range invalid {
+		if err := entry.validate(); err == nil {
+			t.Errorf("expected validation error for %+v", entry)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// How the port's binaries are built and installed
+type Build struct {
+	// Directory to build in, relative to the repo root
+	Dir string `json:"dir,omitempty"`
+	// Main packages to build, relative to Dir. Empty when unknown, in which case
+	// we rely on the PortGroup's default of building Dir as ${name}.
+	Packages []string `json:"packages,omitempty"`
+	// The name each of Packages is installed as
+	Binaries []string `json:"binaries,omitempty"`
+	// The name go build gives each of Packages, if different from Binaries
+	Outputs []string `json:"outputs,omitempty"`
+	// Symbols to set to the port's version with -ldflags -X
+	VersionVars []string `json:"versionVars,omitempty"`
+	// Other linker flags, e.g. from a goreleaser config
+	Ldflags []string `json:"ldflags,omitempty"`
+	// Other go build flags, e.g. -trimpath or -tags
+	Flags []string `json:"flags,omitempty"`
+	// Environment variables for the build, e.g. CGO_ENABLED=0
+	Env []string `json:"env,omitempty"`
+}
+
+// Decide what to build given what was found in the source (nil if unknown) and
+// the commands requested with --cmd, if any
+func newBuild(pkg Package, src *Source, cmds []string) (Build, error) {
+	build := Build{Dir: pkg.srcDir()}
+	if src == nil {
+		if len(cmds) > 0 {
+			return build, fmt.Errorf("Could not read the source of %s to find command %s; check the version, or omit --cmd to build the default package", pkg.Id, cmds[0])
+		}
+		src = &Source{}
+	}
+	// Main packages relative to the build dir, and their directories in the
+	// repo
+	var available, dirs []string
+	for _, main := range src.MainPackages {
+		rel, ok := relativeDir(build.Dir, main)
+		if ok {
+			available = append(available, rel)
+			dirs = append(dirs, main)
+		}
+	}
+	selected := make([]int, len(available))
+	for i := range available {
+		selected[i] = i
+	}
+	if len(cmds) > 0 {
+		selected = nil
+		for _, cmd := range cmds {
+			found := false
+			for i, rel := range available {
+				if rel == cmd || rel == "./"+cmd || binaryName(pkg, rel) == cmd {
+					selected = append(selected, i)
+					found = true
+					break
+				}
+			}
+			if !found && len(available) == 0 {
+				return build, fmt.Errorf("Command %s not found; no main packages were found in the source of %s", cmd, pkg.Id)
+			}
+			if !found {
+				return build, fmt.Errorf("Command %s not found; available commands:\n    %s", cmd, strings.Join(available, "\n    "))
+			}
+		}
+	}
+
+	vars := make(map[string]bool)
+	for _, v := range src.BuildVersionVars {
+		vars[v] = true
+	}
+	for _, i := range selected {
+		for _, v := range src.VersionVars[dirs[i]] {
+			vars[v] = true
+		}
+	}
+	for v := range vars {
+		build.VersionVars = append(build.VersionVars, v)
+	}
+	sort.Strings(build.VersionVars)
+
+	// A single main package at the repo root is what the PortGroup builds by
+	// default
+	if len(selected) == 1 && available[selected[0]] == "." && build.Dir == "" {
+		return build, nil
+	}
+	installed := make(map[string]string)
+	for _, i := range selected {
+		bin := binaryName(pkg, available[i])
+		if other, ok := installed[bin]; ok {
+			return build, fmt.Errorf("Commands %s and %s would both be installed as %s; choose one with --cmd", other, available[i], bin)
+		}
+		installed[bin] = available[i]
+		build.Packages = append(build.Packages, available[i])
+		build.Binaries = append(build.Binaries, bin)
+	}
+	return build, nil
+}
+
+// The path of dir relative to base, if it is within base, in the form the go
+// tool expects (e.g. "./cmd/tool", or "." for base itself)
+func relativeDir(base string, dir string) (string, bool) {
+	if base == "" || base == "." {
+		if dir == "." {
+			return ".", true
+		}
+		return "./" + dir, true
+	}
+	if dir == base {
+		return ".", true
+	}
+	if strings.HasPrefix(dir, base+"/") {
+		return "./" + strings.TrimPrefix(dir, base+"/"), true
+	}
+	return "", false
+}
+
+// The name go build gives the binary for a main package
+func binaryName(pkg Package, rel string) string {
+	if rel == "." {
+		return path.Base(trimSubpath(pkg.Id, pkg.Major))
+	}
+	return path.Base(rel)
+}
+
+func (build *Build) ldflagsStr() string {
+	flags := append([]string{}, build.Ldflags...)
+	if len(flags) == 0 && len(build.VersionVars) == 0 {
+		return ""
+	}
+	for _, v := range build.VersionVars {
+		flags = append(flags, fmt.Sprintf("-X %s=${version}", v))
+	}
+	return fmt.Sprintf(`-ldflags "'%s'"`, strings.Join(flags, " "))
+}
+
+func (build *Build) String() string {
+	var lines []string
+	if build.Dir != "" {
+		lines = append(lines, fmt.Sprintf("build.dir           ${worksrcpath}/%s", build.Dir))
+	}
+	if len(build.Env) > 0 {
+		lines = append(lines, "build.env-append    "+strings.Join(build.Env, " "))
+	}
+	args := append([]string{}, build.Flags...)
+	if ldflags := build.ldflagsStr(); ldflags != "" {
+		args = append(args, ldflags)
+	}
+	if len(build.Packages) > 0 {
+		args = append(args, "-o", "${worksrcpath}/bin/")
+		args = append(args, build.Packages...)
+	}
+	if len(args) > 0 {
+		lines = append(lines, "build.args          "+strings.Join(args, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (build *Build) destrootStr() string {
+	if len(build.Binaries) == 0 {
+		return "destroot {\n    xinstall -m 0755 ${worksrcpath}/${name} ${destroot}${prefix}/bin/\n}"
+	}
+	ret := "destroot {\n"
+	for i, bin := range build.Binaries {
+		if i < len(build.Outputs) && build.Outputs[i] != bin {
+			ret = ret + fmt.Sprintf("    xinstall -m 0755 ${worksrcpath}/bin/%s ${destroot}${prefix}/bin/%s\n", build.Outputs[i], bin)
+		} else {
+			ret = ret + fmt.Sprintf("    xinstall -m 0755 ${worksrcpath}/bin/%s ${destroot}${prefix}/bin/\n", bin)
+		}
+	}
+	return ret + "}"
+}
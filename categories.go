@@ -0,0 +1,133 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// MacPorts categories by repository topic or README keyword. Extended with
+// --category-map.
+var categoryKeywords = map[string]string{
+	"archive":              "archivers",
+	"audio":                "audio",
+	"aws":                  "sysutils",
+	"backup":               "sysutils",
+	"bioinformatics":       "science",
+	"blockchain":           "finance",
+	"cli":                  "sysutils",
+	"command-line":         "sysutils",
+	"compression":          "archivers",
+	"compiler":             "lang",
+	"container":            "sysutils",
+	"cryptography":         "security",
+	"csv":                  "textproc",
+	"database":             "databases",
+	"debugger":             "devel",
+	"devops":               "sysutils",
+	"dns":                  "net",
+	"docker":               "sysutils",
+	"editor":               "editors",
+	"email":                "mail",
+	"emulator":             "emulators",
+	"encryption":           "security",
+	"filesystem":           "sysutils",
+	"game":                 "games",
+	"gis":                  "gis",
+	"git":                  "devel",
+	"graphics":             "graphics",
+	"http":                 "net",
+	"image":                "graphics",
+	"interpreter":          "lang",
+	"irc":                  "irc",
+	"json":                 "textproc",
+	"kubernetes":           "sysutils",
+	"linter":               "devel",
+	"markdown":             "textproc",
+	"math":                 "math",
+	"monitoring":           "sysutils",
+	"music":                "audio",
+	"network":              "net",
+	"networking":           "net",
+	"password":             "security",
+	"pdf":                  "textproc",
+	"programming-language": "lang",
+	"proxy":                "net",
+	"scientific":           "science",
+	"search":               "textproc",
+	"security":             "security",
+	"shell":                "shells",
+	"sql":                  "databases",
+	"ssh":                  "net",
+	"terminal":             "sysutils",
+	"testing":              "devel",
+	"text":                 "textproc",
+	"tui":                  "sysutils",
+	"video":                "multimedia",
+	"vpn":                  "net",
+	"web":                  "www",
+	"webserver":            "www",
+	"xml":                  "textproc",
+	"yaml":                 "textproc",
+}
+
+// Add the entries of a TOML mapping file to categoryKeywords, overriding the
+// built-in ones. Set with --category-map.
+func loadCategoryKeywords(file string) error {
+	var extra struct {
+		Keywords map[string]string `toml:"keywords"`
+	}
+	if _, err := toml.DecodeFile(file, &extra); err != nil {
+		return err
+	}
+	for keyword, category := range extra.Keywords {
+		categoryKeywords[keyword] = category
+	}
+	return nil
+}
+
+// At most this many categories are suggested
+const maxCategories = 2
+
+// A topic counts for this many README mentions
+const topicWeight = 3
+
+// Each keyword counts for at most this many README mentions
+const maxKeywordMentions = 3
+
+// Suggest categories for a project from its topics and README, best first
+func suggestCategories(topics []string, readme string) []string {
+	scores := make(map[string]int)
+	for _, topic := range topics {
+		if category, ok := categoryKeywords[strings.ToLower(topic)]; ok {
+			scores[category] += topicWeight
+		}
+	}
+	text := " " + strings.Join(strings.Fields(nonWordReg.ReplaceAllString(strings.ToLower(readme), " ")), " ") + " "
+	for keyword, category := range categoryKeywords {
+		key := " " + strings.Join(strings.Fields(nonWordReg.ReplaceAllString(keyword, " ")), " ") + " "
+		count := strings.Count(text, key)
+		if count > maxKeywordMentions {
+			count = maxKeywordMentions
+		}
+		scores[category] += count
+	}
+	var categories []string
+	for category, score := range scores {
+		if score > 0 {
+			categories = append(categories, category)
+		}
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		a, b := categories[i], categories[j]
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+		return a < b
+	})
+	if len(categories) > maxCategories {
+		categories = categories[:maxCategories]
+	}
+	return categories
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSuggestCategories(t *testing.T) {
+	readme := "A command-line tool to query your SQL database from the terminal. Works with any SQL database."
+	actual := suggestCategories([]string{"CLI", "go", "database"}, readme)
+	if !reflect.DeepEqual(actual, []string{"databases", "sysutils"}) {
+		t.Errorf("unexpected categories: %v", actual)
+	}
+	if actual = suggestCategories(nil, "Nothing to see here"); len(actual) != 0 {
+		t.Errorf("expected no categories, got %v", actual)
+	}
+}
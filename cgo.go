@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// MacPorts ports providing the libraries that cgo code asks for. An empty port
+// name means the library is provided by macOS.
+type CgoPortMap struct {
+	// By pkg-config module name
+	PkgConfig map[string]string `toml:"pkg-config"`
+	// By library name, as given to -l
+	Libs map[string]string `toml:"libs"`
+}
+
+var cgoPorts = CgoPortMap{
+	PkgConfig: map[string]string{
+		"fuse":          "macfuse",
+		"gio-2.0":       "glib2",
+		"glib-2.0":      "glib2",
+		"gpgme":         "gpgme",
+		"gtk+-3.0":      "gtk3",
+		"icu-uc":        "icu",
+		"lept":          "leptonica",
+		"libcrypto":     "openssl",
+		"libgit2":       "libgit2",
+		"libheif":       "libheif",
+		"liblzma":       "xz",
+		"libsodium":     "libsodium",
+		"libssl":        "openssl",
+		"libusb-1.0":    "libusb",
+		"libxml-2.0":    "libxml2",
+		"libzstd":       "zstd",
+		"MagickWand":    "ImageMagick",
+		"openssl":       "openssl",
+		"opus":          "libopus",
+		"portaudio-2.0": "portaudio",
+		"sdl2":          "libsdl2",
+		"sqlite3":       "sqlite3",
+		"tesseract":     "tesseract",
+		"vips":          "vips",
+		"x11":           "xorg-libX11",
+		"zlib":          "zlib",
+	},
+	Libs: map[string]string{
+		"archive":  "libarchive",
+		"bz2":      "bzip2",
+		"c":        "",
+		"crypto":   "openssl",
+		"curl":     "curl",
+		"dl":       "",
+		"git2":     "libgit2",
+		"gpgme":    "gpgme",
+		"iconv":    "libiconv",
+		"lzma":     "xz",
+		"m":        "",
+		"ncurses":  "ncurses",
+		"objc":     "",
+		"pcap":     "libpcap",
+		"pthread":  "",
+		"readline": "readline",
+		"resolv":   "",
+		"sodium":   "libsodium",
+		"sqlite3":  "sqlite3",
+		"ssl":      "openssl",
+		"System":   "",
+		"usb-1.0":  "libusb",
+		"xml2":     "libxml2",
+		"z":        "zlib",
+		"zstd":     "zstd",
+	},
+}
+
+// Add the entries of a TOML mapping file to cgoPorts, overriding the built-in
+// ones. Set with --cgo-ports.
+func loadCgoPorts(file string) error {
+	extra := CgoPortMap{}
+	if _, err := toml.DecodeFile(file, &extra); err != nil {
+		return err
+	}
+	for name, port := range extra.PkgConfig {
+		cgoPorts.PkgConfig[name] = port
+	}
+	for name, port := range extra.Libs {
+		cgoPorts.Libs[name] = port
+	}
+	return nil
+}
+
+// A module whose source we have scanned, and the directory of its root within
+// its tarball
+type scannedModule struct {
+	path string
+	dir  string
+	src  *Source
+}
+
+// A package directory within one of a list of scanned modules
+type moduleDir struct {
+	module int
+	dir    string
+}
+
+// Find the module providing an import path, if any, and the package's
+// directory within it
+func resolveImport(modules []scannedModule, imp string) (moduleDir, bool) {
+	best := -1
+	for i, m := range modules {
+		if imp != m.path && !strings.HasPrefix(imp, m.path+"/") {
+			continue
+		}
+		if best < 0 || len(m.path) > len(modules[best].path) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return moduleDir{}, false
+	}
+	m := modules[best]
+	return moduleDir{best, path.Join(".", m.dir, strings.TrimPrefix(imp, m.path))}, true
+}
+
+// The cgo packages the build uses: those reachable by imports from the main
+// packages being built, which are in the first of modules. Packages of vendored
+// repos that aren't imported, like the many platform-specific ones of
+// golang.org/x/sys, don't count.
+func usedCgoPackages(build Build, modules []scannedModule) []CgoPackage {
+	var queue []moduleDir
+	if len(build.Packages) == 0 {
+		queue = append(queue, moduleDir{0, path.Join(".", build.Dir)})
+	}
+	for _, p := range build.Packages {
+		queue = append(queue, moduleDir{0, path.Join(".", build.Dir, p)})
+	}
+	seen := make(map[moduleDir]bool)
+	var ret []CgoPackage
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if seen[next] {
+			continue
+		}
+		seen[next] = true
+		src := modules[next.module].src
+		for _, c := range src.Cgo {
+			if c.Dir == next.dir {
+				ret = append(ret, c)
+			}
+		}
+		for _, imp := range src.Imports[next.dir] {
+			if dir, ok := resolveImport(modules, imp); ok {
+				queue = append(queue, dir)
+			}
+		}
+	}
+	return ret
+}
+
+// The ports providing the libraries used by the given cgo packages, and the
+// pkg-config modules and libraries we don't know a port for
+func cgoDependencies(pkgs []CgoPackage) ([]string, []string) {
+	var ports, unknown []string
+	for _, c := range pkgs {
+		for _, name := range c.PkgConfig {
+			if port, ok := cgoPorts.PkgConfig[name]; !ok {
+				unknown = appendUnique(unknown, "pkg-config "+name)
+			} else if port != "" {
+				ports = appendUnique(ports, port)
+			}
+		}
+		for _, name := range c.Libs {
+			if port, ok := cgoPorts.Libs[name]; !ok {
+				unknown = appendUnique(unknown, "-l"+name)
+			} else if port != "" {
+				ports = appendUnique(ports, port)
+			}
+		}
+	}
+	sort.Strings(ports)
+	return ports, unknown
+}
+
+func dependsLibStr(ports []string) string {
+	if len(ports) == 0 {
+		return ""
+	}
+	var deps []string
+	for _, port := range ports {
+		deps = append(deps, "port:"+port)
+	}
+	return "depends_lib-append  " + strings.Join(deps, " \\\n                    ")
+}
+
+// Apply what we know about cgo use in the packages being built to build:
+// disable cgo if no package uses it, or return the ports to add to
+// depends_lib. Nothing is done if the build already sets CGO_ENABLED, or if
+// any tarball could not be read, in which case the unread vendors are warned
+// about.
+func applyCgo(build *Build, pkg Package, src *Source, vendors []Vendor) ([]string, []string) {
+	for _, env := range build.Env {
+		if env == "CGO_ENABLED=0" {
+			return nil, nil
+		}
+	}
+	if src == nil {
+		return nil, nil
+	}
+	var warnings []string
+	modules := []scannedModule{{path: pkg.Id, dir: pkg.srcDir(), src: src}}
+	for _, vendor := range vendors {
+		if vendor.Source == nil {
+			warnings = append(warnings, fmt.Sprintf("Could not read the source of vendor %s; not checking the build's use of cgo", vendor.Name))
+			continue
+		}
+		modules = append(modules, scannedModule{path: vendor.Name, dir: vendor.Package.srcDir(), src: vendor.Source})
+	}
+	if len(warnings) > 0 {
+		return nil, warnings
+	}
+	cgoPkgs := usedCgoPackages(*build, modules)
+	if len(cgoPkgs) == 0 {
+		if !strings.Contains(strings.Join(build.Env, " "), "CGO_ENABLED=") {
+			build.Env = append(build.Env, "CGO_ENABLED=0")
+		}
+		return nil, nil
+	}
+	ports, unknown := cgoDependencies(cgoPkgs)
+	for _, name := range unknown {
+		warnings = append(warnings, fmt.Sprintf("No MacPorts port known for cgo dependency %s; add it to depends_lib by hand or map it with --cgo-ports", name))
+	}
+	return ports, warnings
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Per-user settings, read from --config
+type Config struct {
+	Maintainer Maintainer `toml:"maintainer"`
+	Tokens     Tokens     `toml:"tokens"`
+}
+
+// API tokens for forges, used when querying them for metadata. The
+// GITHUB_TOKEN and GITLAB_TOKEN environment variables take precedence.
+type Tokens struct {
+	GitHub string `toml:"github"`
+	GitLab string `toml:"gitlab"`
+}
+
+// The identity to put in the maintainers line of generated portfiles
+type Maintainer struct {
+	Email  string `toml:"email"`
+	GitHub string `toml:"github"`
+	// Whether to allow minor changes by others without review
+	OpenMaintainer bool `toml:"openmaintainer"`
+}
+
+var userConfig = Config{}
+
+// ~/Library/Application Support/go2port/config.toml on macOS
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "go2port", "config.toml")
+}
+
+// Read the config file. A missing file is only an error if it was asked for
+// explicitly.
+func loadConfig(file string, explicit bool) error {
+	if file == "" {
+		return nil
+	}
+	_, err := toml.DecodeFile(file, &userConfig)
+	if err != nil && !explicit && errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// The maintainer in MacPorts' obfuscated form, e.g. "{example.com:foo @foo}".
+// Addresses at macports.org are given as just the handle.
+func (m *Maintainer) String() string {
+	var parts []string
+	if m.Email != "" {
+		email := m.Email
+		if at := strings.LastIndex(email, "@"); at >= 0 {
+			user, domain := email[:at], email[at+1:]
+			if domain == "macports.org" {
+				email = user
+			} else {
+				email = fmt.Sprintf("%s:%s", domain, user)
+			}
+		}
+		parts = append(parts, email)
+	}
+	if m.GitHub != "" {
+		parts = append(parts, "@"+strings.TrimPrefix(m.GitHub, "@"))
+	}
+	var ret string
+	switch len(parts) {
+	case 0:
+		return ""
+	case 1:
+		ret = parts[0]
+	default:
+		ret = "{" + strings.Join(parts, " ") + "}"
+	}
+	if m.OpenMaintainer {
+		ret += " openmaintainer"
+	}
+	return ret
+}
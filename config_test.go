@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMaintainerString(t *testing.T) {
+	tests := []struct {
+		maintainer Maintainer
+		expected   string
+	}{
+		{Maintainer{Email: "amake@macports.org", GitHub: "amake", OpenMaintainer: true}, "{amake @amake} openmaintainer"},
+		{Maintainer{Email: "foo@example.com", GitHub: "@foo"}, "{example.com:foo @foo}"},
+		{Maintainer{Email: "foo@example.com"}, "example.com:foo"},
+		{Maintainer{GitHub: "foo", OpenMaintainer: true}, "@foo openmaintainer"},
+		{Maintainer{}, ""},
+	}
+	for _, test := range tests {
+		if actual := test.maintainer.String(); actual != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, actual)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	defer func() { userConfig = Config{} }()
+	file := filepath.Join(t.TempDir(), "config.toml")
+	if err := loadConfig(file, false); err != nil {
+		t.Errorf("missing default config should be ignored: %v", err)
+	}
+	if err := loadConfig(file, true); err == nil {
+		t.Errorf("expected error for missing explicit config")
+	}
+	content := "[maintainer]\nemail = \"foo@example.com\"\ngithub = \"foo\"\nopenmaintainer = true\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(file, true); err != nil {
+		t.Fatal(err)
+	}
+	if userConfig.Maintainer.String() != "{example.com:foo @foo} openmaintainer" {
+		t.Errorf("unexpected maintainer: %+v", userConfig.Maintainer)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+// Maximum number of concurrent downloads across all ports being processed.
+// Set with --jobs.
+var jobs = 8
+
+// The client used by fetch. Tests replace its transport to stay offline.
+var httpClient = &http.Client{}
+
+var fetchSlots chan struct{}
+var fetchSlotsOnce sync.Once
+
+// Download the resource at url, holding one of the global download slots for
+// the duration. Returns the HTTP status and the response body.
+func fetch(url string) (int, []byte, error) {
+	return fetchWithHeader(url, nil)
+}
+
+// As fetch, sending extra request headers, e.g. for API authentication
+func fetchWithHeader(url string, header http.Header) (int, []byte, error) {
+	fetchSlotsOnce.Do(func() {
+		n := jobs
+		if n < 1 {
+			n = 1
+		}
+		fetchSlots = make(chan struct{}, n)
+	})
+	fetchSlots <- struct{}{}
+	defer func() { <-fetchSlots }()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return res.StatusCode, nil, err
+	}
+	return res.StatusCode, body, nil
+}
+
+// A memo de-duplicates concurrent and repeated calls with the same key, so
+// e.g. two ports sharing a dependency at the same revision only download it
+// once.
+type memo[T any] struct {
+	mu      sync.Mutex
+	entries map[string]*memoEntry[T]
+}
+
+type memoEntry[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+func (m *memo[T]) get(key string, f func() (T, error)) (T, error) {
+	m.mu.Lock()
+	if m.entries == nil {
+		m.entries = make(map[string]*memoEntry[T])
+	}
+	entry, ok := m.entries[key]
+	if !ok {
+		entry = &memoEntry[T]{done: make(chan struct{})}
+		m.entries[key] = entry
+	}
+	m.mu.Unlock()
+
+	if ok {
+		<-entry.done
+	} else {
+		entry.value, entry.err = f()
+		close(entry.done)
+	}
+	return entry.value, entry.err
+}
+
+// What we retain about a downloaded tarball. The tarball itself is not kept in
+// memory.
+type Tarball struct {
+	Status    int
+	Checksums Checksums
+	// Nil if the contents could not be read
+	Source *Source
+}
+
+// Tarballs by scan mode and URL
+var tarballs memo[Tarball]
+
+// What we write in place of checksums that could not be calculated
+var placeholderChecksums = Checksums{Rmd160: "0", Sha256: "0", Size: "0"}
+
+// Download and checksum the tarball at url, scanning its contents as far as
+// mode requires. The port's own source and its dependencies rarely share a
+// URL, so each mode is memoized separately.
+func fetchTarball(url string, mode scanMode) (Tarball, error) {
+	return tarballs.get(tarballKey(url, mode), func() (Tarball, error) {
+		ret := Tarball{Checksums: placeholderChecksums}
+		status, tarball, err := fetch(url)
+		ret.Status = status
+		if err != nil || status != 200 {
+			return ret, err
+		}
+
+		ret.Checksums.Size = fmt.Sprintf("%d", len(tarball))
+
+		sha := sha256.New()
+		sha.Write(tarball)
+		ret.Checksums.Sha256 = fmt.Sprintf("%x", sha.Sum(nil))
+
+		rmd := ripemd160.New()
+		rmd.Write(tarball)
+		ret.Checksums.Rmd160 = fmt.Sprintf("%x", rmd.Sum(nil))
+
+		if mode == scanChecksums {
+			return ret, nil
+		}
+		src, err := scanSource(tarball, mode)
+		if err == nil {
+			ret.Source = &src
+		} else if debugOn {
+			log.Printf("Could not read contents of %s: %s", url, err)
+		}
+
+		return ret, nil
+	})
+}
+
+func tarballKey(url string, mode scanMode) string {
+	return fmt.Sprintf("%d %s", mode, url)
+}
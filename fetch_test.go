@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Requests made through a fakeTransport, by URL
+type requestLog struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (l *requestLog) count(url string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.counts[url]
+}
+
+// Serves bodies by URL instead of going to the network. Other URLs are 404.
+type fakeTransport struct {
+	bodies map[string]string
+	log    *requestLog
+}
+
+func (f *fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	url := req.URL.String()
+	f.log.mu.Lock()
+	f.log.counts[url]++
+	f.log.mu.Unlock()
+	status := http.StatusOK
+	body, ok := f.bodies[url]
+	if !ok {
+		status = http.StatusNotFound
+	}
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// Serve bodies by URL for the rest of the test, instead of the network
+func fakeHttp(t *testing.T, bodies map[string]string) *requestLog {
+	log := &requestLog{counts: make(map[string]int)}
+	client := httpClient
+	httpClient = &http.Client{Transport: &fakeTransport{bodies: bodies, log: log}}
+	t.Cleanup(func() { httpClient = client })
+	return log
+}
+
+func TestMemoDeduplicates(t *testing.T) {
+	var m memo[string]
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := m.get("key", func() (string, error) {
+				atomic.AddInt32(&calls, 1)
+				return "value", nil
+			})
+			if err != nil || v != "value" {
+				t.Errorf("unexpected result: %q, %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("expected 1 call; got %d", calls)
+	}
+	v, _ := m.get("other", func() (string, error) { return "other", nil })
+	if v != "other" {
+		t.Fatalf("unexpected value for distinct key: %q", v)
+	}
+}
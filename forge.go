@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Public Gitea and Forgejo instances, which need no detection
+var knownGiteaHosts = map[string]bool{
+	"codeberg.org": true,
+	"gitea.com":    true,
+}
+
+var detectedGiteaHosts sync.Map
+
+// Record that a host serves Gitea-style go-source metadata, as seen when
+// resolving a package through its go-get page
+func markGiteaHost(host string) {
+	if debugOn {
+		log.Printf("Detected Gitea/Forgejo instance at %s", host)
+	}
+	detectedGiteaHosts.Store(host, true)
+}
+
+// Gitea (and its fork Forgejo) renders go-source source links as
+// .../src/branch/<branch>{/dir}, which no other forge does
+func isGiteaGoSource(content string) bool {
+	fields := strings.Fields(content)
+	return len(fields) >= 3 && strings.Contains(fields[2], "/src/branch/")
+}
+
+var giteaProbes memo[bool]
+
+// Whether host is a Gitea or Forgejo instance. Self-hosted instances are
+// detected from their go-source metadata or by probing the version API
+// endpoint common to both.
+func isGitea(host string) bool {
+	if knownGiteaHosts[host] {
+		return true
+	}
+	if _, ok := detectedGiteaHosts.Load(host); ok {
+		return true
+	}
+	ret, _ := giteaProbes.get(host, func() (bool, error) {
+		status, body, err := fetch("https://" + host + "/api/v1/version")
+		if err != nil || status != 200 {
+			return false, err
+		}
+		var version struct {
+			Version string `json:"version"`
+		}
+		if json.Unmarshal(body, &version) != nil || version.Version == "" {
+			return false, nil
+		}
+		if debugOn {
+			log.Printf("Detected Gitea/Forgejo %s at %s", version.Version, host)
+		}
+		return true, nil
+	})
+	return ret
+}
+
+// GitHub mirrors of googlesource.com repositories, whose own archives are not
+// byte-stable and so can't be checksummed
+var googlesourceMirrors = map[string]string{
+	"go.googlesource.com/crypto":                 "github.com/golang/crypto",
+	"go.googlesource.com/mod":                    "github.com/golang/mod",
+	"go.googlesource.com/net":                    "github.com/golang/net",
+	"go.googlesource.com/oauth2":                 "github.com/golang/oauth2",
+	"go.googlesource.com/protobuf":               "github.com/protocolbuffers/protobuf-go",
+	"go.googlesource.com/sync":                   "github.com/golang/sync",
+	"go.googlesource.com/sys":                    "github.com/golang/sys",
+	"go.googlesource.com/term":                   "github.com/golang/term",
+	"go.googlesource.com/text":                   "github.com/golang/text",
+	"go.googlesource.com/time":                   "github.com/golang/time",
+	"go.googlesource.com/tools":                  "github.com/golang/tools",
+	"code.googlesource.com/gocloud":              "github.com/googleapis/google-cloud-go",
+	"code.googlesource.com/google-api-go-client": "github.com/googleapis/google-api-go-client",
+	"gvisor.googlesource.com/gvisor":             "github.com/google/gvisor",
+}
+
+// Map a resolved googlesource.com repo (as host and path parts) to its GitHub
+// mirror, if known
+func googlesourceMirror(parts []string) []string {
+	id := strings.Join(parts, "/")
+	mirror, ok := googlesourceMirrors[id]
+	if !ok {
+		return parts
+	}
+	if debugOn {
+		log.Printf("Using mirror %s for %s", mirror, id)
+	}
+	return strings.Split(mirror, "/")
+}
+
+func googlesourceError(pkg Package) error {
+	if mirror, ok := googlesourceMirrors[pkg.ResolvedId]; ok {
+		return fmt.Errorf(`%s resolves to %s, which does not serve reproducible tarballs
+Add a repo override for its GitHub mirror to its go.vendors entry:
+    %s \
+        repo    %s \
+        lock    %s`, pkg.Id, pkg.ResolvedId, pkg.Id, mirror, pkg.Version)
+	}
+	return fmt.Errorf(`%s resolves to %s, which does not serve reproducible tarballs, and no mirror of it is known
+Find a mirror of it (e.g. on GitHub) and add it as a repo override to its go.vendors entry:
+    %s \
+        repo    <mirror> \
+        lock    %s`, pkg.Id, pkg.ResolvedId, pkg.Id, pkg.Version)
+}
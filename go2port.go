@@ -2,23 +2,20 @@ package main
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"regexp"
 	"sort"
 	"strings"
-	"text/template"
 
 	"github.com/BurntSushi/toml"
 	"github.com/urfave/cli"
-	"golang.org/x/crypto/ripemd160"
 	"golang.org/x/mod/modfile"
 	"golang.org/x/mod/module"
 	"golang.org/x/mod/semver"
@@ -45,6 +42,47 @@ func main() {
 			Usage:       "print debug information",
 			Destination: &debugOn,
 		},
+		cli.IntFlag{
+			Name:        "jobs, j",
+			Usage:       "maximum number of concurrent downloads",
+			Value:       jobs,
+			Destination: &jobs,
+		},
+		cli.StringFlag{
+			Name:        "macports-go",
+			Usage:       "`VERSION` of the MacPorts go port, to check go.mod requirements against (default: as reported by port info)",
+			Value:       macportsGo,
+			Destination: &macportsGo,
+		},
+		cli.StringFlag{
+			Name:  "cgo-ports",
+			Usage: "TOML `FILE` mapping cgo pkg-config modules and libraries to MacPorts ports",
+		},
+		cli.StringFlag{
+			Name:  "config",
+			Usage: "user config `FILE`",
+			Value: defaultConfigFile(),
+		},
+		cli.StringFlag{
+			Name:  "category-map",
+			Usage: "TOML `FILE` mapping repository topics and README keywords to MacPorts categories",
+		},
+	}
+	app.Before = func(c *cli.Context) error {
+		if err := loadConfig(c.GlobalString("config"), c.GlobalIsSet("config")); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		if file := c.GlobalString("cgo-ports"); file != "" {
+			if err := loadCgoPorts(file); err != nil {
+				return cli.NewExitError(err, 1)
+			}
+		}
+		if file := c.GlobalString("category-map"); file != "" {
+			if err := loadCategoryKeywords(file); err != nil {
+				return cli.NewExitError(err, 1)
+			}
+		}
+		return nil
 	}
 	app.Commands = []cli.Command{
 		{
@@ -59,9 +97,20 @@ func main() {
 				},
 				cli.StringFlag{
 					Name:  "dir, d",
-					Usage: "directory of lockfile in repo",
-					Value: "/",
+					Usage: "directory of lockfile in repo (default: the module's directory)",
 				},
+				formatFlag,
+				tarballFromFlag,
+				releaseAssetFlag,
+				cmdFlag,
+				versionLdflagsFlag,
+				cgoFlag,
+				licenseFlag,
+				vendorLicensesFlag,
+				metadataFlag,
+				categoriesFlag,
+				templateFlag,
+				groupIndirectFlag,
 			},
 
 			Action: generate,
@@ -75,9 +124,45 @@ func main() {
 					Name:  "output, o",
 					Usage: "output `FILE` (\"-\" for stdout)",
 				},
+				formatFlag,
+				tarballFromFlag,
+				releaseAssetFlag,
+				cmdFlag,
+				versionLdflagsFlag,
+				cgoFlag,
+				licenseFlag,
+				vendorLicensesFlag,
+				metadataFlag,
+				categoriesFlag,
+				templateFlag,
+				groupIndirectFlag,
 			},
 			Action: update,
 		},
+		{
+			Name:      "lint",
+			Usage:     "Check the go.vendors of existing MacPorts portfiles against upstream",
+			ArgsUsage: "<portname> ...",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "dir, d",
+					Usage: "directory of lockfile in repo (default: the module's directory)",
+				},
+			},
+			Action: lint,
+		},
+		{
+			Name:      "verify",
+			Usage:     "Re-download the distfiles of existing MacPorts portfiles and check their checksums",
+			ArgsUsage: "<portname> ...",
+			Action:    verify,
+		},
+		{
+			Name:      "batch",
+			Usage:     "Generate or update several portfiles described by a manifest",
+			ArgsUsage: "<manifest.toml>",
+			Action:    batch,
+		},
 	}
 
 	err := app.Run(os.Args)
@@ -88,57 +173,210 @@ func main() {
 
 var debugOn = false
 
+var formatFlag = cli.StringFlag{
+	Name:  "format, f",
+	Usage: "output `FORMAT` (\"portfile\" or \"json\")",
+	Value: "portfile",
+}
+
+var releaseAssetFlag = cli.StringFlag{
+	Name:  "release-asset",
+	Usage: "use the GitHub release asset matching `PATTERN` as the main distfile",
+}
+
+var cmdFlag = cli.StringSliceFlag{
+	Name:  "cmd",
+	Usage: "build and install only the command at `DIR` or with binary NAME (may be repeated)",
+}
+
+var versionLdflagsFlag = cli.BoolFlag{
+	Name:  "version-ldflags",
+	Usage: "set version variables found in the source to ${version} with -ldflags -X",
+}
+
+var cgoFlag = cli.BoolFlag{
+	Name:  "cgo",
+	Usage: "add depends_lib for the C libraries cgo code uses, or build with CGO_ENABLED=0 if there is none",
+}
+
+var licenseFlag = cli.BoolFlag{
+	Name:  "license",
+	Usage: "fill in license from the license file in the source",
+}
+
+var vendorLicensesFlag = cli.BoolFlag{
+	Name:  "vendor-licenses",
+	Usage: "detect the licenses of vendored modules, warn about copyleft or unknown ones, and list them in a comment",
+}
+
+var groupIndirectFlag = cli.BoolFlag{
+	Name:  "group-indirect",
+	Usage: "list modules marked // indirect in go.mod in a separate go.vendors block",
+}
+
+var templateFlag = cli.StringFlag{
+	Name:  "template, t",
+	Usage: "portfile template `FILE` to use instead of the built-in one (or, when updating, the existing portfile)",
+}
+
+var metadataFlag = cli.BoolFlag{
+	Name:  "metadata",
+	Usage: "fill in the description and homepage from the forge's API and the README",
+}
+
+var categoriesFlag = cli.BoolFlag{
+	Name:  "categories",
+	Usage: "suggest categories from the repository's topics (with --metadata) and README",
+}
+
+var tarballFromFlag = cli.StringFlag{
+	Name:  "tarball-from",
+	Usage: "`SOURCE` of GitHub distfiles (\"archive\", \"tarball\", or \"releases\"); must match the portfile's github.tarball_from",
+}
+
+// Settings that affect how a single port is generated
+type Options struct {
+	// Directory of lockfile in repo
+	LockfileDir string
+	// Source of GitHub distfiles, as for github.tarball_from. Empty means the
+	// PortGroup default.
+	TarballFrom string
+	// Pattern matching the name of a GitHub release asset to use as the main
+	// distfile instead of the source tarball
+	ReleaseAsset string
+	// Commands to build and install, by directory or binary name. Empty means
+	// all main packages found.
+	Commands []string
+	// Whether to set detected version variables with -ldflags -X
+	VersionLdflags bool
+	// Whether to check for cgo use and set depends_lib or CGO_ENABLED
+	Cgo bool
+	// Whether to detect the license from the source
+	License bool
+	// Whether to list the licenses of vendored modules in a comment
+	VendorLicenses bool
+	// Whether to query the forge and README for project metadata
+	Metadata bool
+	// Whether to suggest categories
+	Categories bool
+	// Contents of a portfile template to use instead of the default one
+	Template string
+	// Whether to list indirect dependencies separately
+	GroupIndirect bool
+}
+
+func optionsFrom(c *cli.Context) (Options, error) {
+	opts := Options{
+		LockfileDir:    c.String("dir"),
+		TarballFrom:    c.String("tarball-from"),
+		ReleaseAsset:   c.String("release-asset"),
+		Commands:       c.StringSlice("cmd"),
+		VersionLdflags: c.Bool("version-ldflags"),
+		Cgo:            c.Bool("cgo"),
+		License:        c.Bool("license"),
+		VendorLicenses: c.Bool("vendor-licenses"),
+		Metadata:       c.Bool("metadata"),
+		Categories:     c.Bool("categories"),
+		GroupIndirect:  c.Bool("group-indirect"),
+	}
+	if err := checkTarballFrom(opts.TarballFrom); err != nil {
+		return opts, err
+	}
+	if file := c.String("template"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return opts, err
+		}
+		opts.Template = string(data)
+	}
+	return opts, nil
+}
+
+func checkFormat(format string) error {
+	switch format {
+	case "portfile", "json":
+		return nil
+	default:
+		return fmt.Errorf("Unsupported output format: %s", format)
+	}
+}
+
 var portfileTemplate = `# -*- coding: utf-8; mode: tcl; tab-width: 4; indent-tabs-mode: nil; c-basic-offset: 4 -*- vim:fenc=utf-8:ft=tcl:et:sw=4:ts=4:sts=4
 
 PortSystem          1.0
 PortGroup           golang 1.0
 
-go.setup            {{.PackageId}} {{.Version}}
-categories
-maintainers
-license
+go.setup            {{.PackageId}} {{.Version}}{{with .TagPrefix}} {{.}}{{end}}
+{{with .TarballFrom}}{{.}}
+{{end}}categories{{with .Categories}}          {{.}}{{end}}
+maintainers{{with .Maintainers}}         {{.}}{{end}}
+{{with .LicenseNote}}{{.}}
+{{end}}license{{with .License}}             {{.}}{{end}}
+{{with .VendorLicenses}}{{.}}
+{{end}}
+description{{with .Description}}         {{.}}{{end}}
+
+long_description{{with .LongDescription}}    {{.}}{{end}}
+{{with .Homepage}}
+homepage            {{.}}
+{{end}}
+{{.PackageAlias}}{{with .Distfile}}{{.}}
+{{end}}{{.Checksums}}
 
-description
+{{.GoVendors}}
 
-long_description
+{{with .DependsBuild}}{{.}}
 
-{{.PackageAlias}}{{.Checksums}}
+{{end}}{{with .DependsLib}}{{.}}
 
-{{.GoVendors}}
+{{end}}{{with .Build}}{{.}}
 
-destroot {
-    xinstall -m 0755 ${worksrcpath}/${name} ${destroot}${prefix}/bin/
-}
+{{end}}{{.Destroot}}
 `
 
 func generate(c *cli.Context) error {
 	if c.NArg()%2 != 0 {
 		return cli.NewExitError("Please specify a package and version (tag or SHA1)", 1)
 	}
+	format := c.String("format")
+	if err := checkFormat(format); err != nil {
+		return cli.NewExitError(err, 1)
+	}
 	outfile := c.String("output")
 	if c.NArg() > 2 && outfile != "-" && outfile != "" {
 		log.Println("WARNING: Output file ignored in batch mode")
 		outfile = ""
 	}
-	for i := 0; i < c.NArg(); i = i + 2 {
-		pkgstr := c.Args().Get(i)
-		version := c.Args().Get(i + 1)
-		if debugOn {
-			log.Printf("Generating portfile for %s (%s)", pkgstr, version)
-		}
-		pkg, err := newPackage(pkgstr, version)
-		if err != nil {
-			return cli.NewExitError(err, 1)
-		}
-		lockfileDir := c.String("dir")
-		portfile, err := generateOne(pkg, portfileTemplate, lockfileDir)
-		if err != nil {
-			return cli.NewExitError(err, 1)
+	opts, err := optionsFrom(c)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var g errgroup.Group
+	count := c.NArg() / 2
+	outputs := make([][]byte, count)
+	errs := make([]error, count)
+	for i := 0; i < count; i++ {
+		i := i
+		pkgstr := c.Args().Get(i * 2)
+		version := c.Args().Get(i*2 + 1)
+		g.Go(func() error {
+			outputs[i], errs[i] = generateOutput(pkgstr, version, opts, format)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	// Write results in the order requested, stopping at the first failure
+	for i, out := range outputs {
+		if errs[i] != nil {
+			return cli.NewExitError(errs[i], 1)
 		}
+		var err error
 		if outfile == "-" {
-			_, err = fmt.Print(string(portfile))
+			_, err = fmt.Print(string(out))
 		} else {
-			err = os.WriteFile(outfile, portfile, 0755)
+			err = os.WriteFile(outfile, out, 0755)
 		}
 		if err != nil {
 			return cli.NewExitError(err, 1)
@@ -147,21 +385,67 @@ func generate(c *cli.Context) error {
 	return nil
 }
 
+func generateOutput(pkgstr string, version string, opts Options, format string) ([]byte, error) {
+	if debugOn {
+		log.Printf("Generating portfile for %s (%s)", pkgstr, version)
+	}
+	pkg, err := newPackage(pkgstr, version)
+	if err != nil {
+		return nil, err
+	}
+	tmplate := portfileTemplate
+	if opts.Template != "" {
+		tmplate = opts.Template
+	}
+	result, err := generateOne(pkg, tmplate, opts)
+	if err != nil {
+		return nil, err
+	}
+	if format == "json" {
+		return result.json()
+	}
+	return []byte(result.Portfile), nil
+}
+
 func update(c *cli.Context) error {
 	if c.NArg()%2 != 0 {
 		return cli.NewExitError("Please specify a package and version (tag or SHA1)", 1)
 	}
+	format := c.String("format")
+	if err := checkFormat(format); err != nil {
+		return cli.NewExitError(err, 1)
+	}
 	outfile := c.String("output")
 	if c.NArg() > 2 && outfile != "-" && outfile != "" {
 		log.Println("WARNING: Output file ignored in batch mode")
 		outfile = ""
 	}
-	for i := 0; i < c.NArg(); i = i + 2 {
-		portname := c.Args().Get(i)
-		version := c.Args().Get(i + 1)
-		lockfileDir := c.String("dir")
-		err := updateOne(portname, version, outfile, lockfileDir)
-		if err != nil {
+	opts, err := optionsFrom(c)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var g errgroup.Group
+	count := c.NArg() / 2
+	outputs := make([][]byte, count)
+	errs := make([]error, count)
+	for i := 0; i < count; i++ {
+		i := i
+		portname := c.Args().Get(i * 2)
+		version := c.Args().Get(i*2 + 1)
+		g.Go(func() error {
+			outputs[i], errs[i] = updateOne(portname, version, outfile, opts, format)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	// Print results in the order requested, stopping at the first failure
+	for i, out := range outputs {
+		if errs[i] != nil {
+			return cli.NewExitError(errs[i], 1)
+		}
+		if _, err := fmt.Print(string(out)); err != nil {
 			return cli.NewExitError(err, 1)
 		}
 	}
@@ -182,29 +466,126 @@ func getPortfilePath(portname string) (string, error) {
 	return strings.TrimSpace(stdout.String()), nil
 }
 
-func updateOne(portname string, version string, outfile string, lockfileDir string) error {
-	toStdOut := outfile == "-"
+// Locate the existing portfile for portname and read the package it describes.
+// Returns the package at the new version, the existing portfile's path, and its
+// contents.
+func existingPortfile(portname string, version string) (Package, string, string, error) {
+	portfilePath, portfileOldStr, err := readPortfile(portname)
+	if err != nil {
+		return Package{}, "", "", err
+	}
+	pkg, err := portfilePackage(portfilePath, portfileOldStr, version)
+	if err != nil {
+		return Package{}, "", "", err
+	}
+	return pkg, portfilePath, portfileOldStr, nil
+}
+
+// The path and contents of the portfile for portname
+func readPortfile(portname string) (string, string, error) {
 	portfilePath, err := getPortfilePath(portname)
 	if err != nil {
-		return err
+		return "", "", err
 	}
-	portfileOld, err := os.ReadFile(portfilePath)
+	portfile, err := os.ReadFile(portfilePath)
 	if err != nil {
-		return err
+		return "", "", err
+	}
+	return portfilePath, string(portfile), nil
+}
+
+// Run check on each port given as an argument concurrently, and print the
+// problems it finds. Fails if any port has problems.
+func forEachPort(c *cli.Context, check func(string) ([]string, error)) error {
+	if c.NArg() == 0 {
+		return cli.NewExitError("Please specify a port", 1)
+	}
+	portnames := c.Args()
+	problems := make([][]string, len(portnames))
+	errs := make([]error, len(portnames))
+	var g errgroup.Group
+	for i, portname := range portnames {
+		i, portname := i, portname
+		g.Go(func() error {
+			problems[i], errs[i] = check(portname)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	failed := 0
+	for i, portname := range portnames {
+		switch {
+		case errs[i] != nil:
+			log.Printf("%s: FAILED: %s", portname, errs[i])
+			failed++
+		case len(problems[i]) > 0:
+			for _, problem := range problems[i] {
+				fmt.Printf("%s: %s\n", portname, problem)
+			}
+			failed++
+		default:
+			log.Printf("%s: ok", portname)
+		}
+	}
+	if failed > 0 {
+		msg := fmt.Sprintf("%d of %d ports have problems", failed, len(portnames))
+		return cli.NewExitError(msg, 1)
+	}
+	return nil
+}
+
+// The package a portfile describes, at version
+func portfilePackage(portfilePath string, portfile string, version string) (Package, error) {
+	pkgstr, _ := packageFromPortfile(portfile)
+	// go.package is the module's actual path when it differs from the repo
+	if match := goPackageRegexp.FindStringSubmatch(portfile); len(match) == 2 {
+		pkgstr = match[1]
 	}
-	portfileOldStr := string(portfileOld)
-	pkgstr, err := packageFromPortfile(portfileOldStr)
 	if pkgstr == "" {
 		msg := fmt.Sprintf("Could not detect Go package from portfile %s", portfilePath)
-		return errors.New(msg)
+		return Package{}, errors.New(msg)
 	}
-	pkg, err := newPackage(pkgstr, version)
+	tagPrefix := tagPrefixFromPortfile(portfile)
+	// Accept the version with or without the prefix, e.g. v1.2.3 or 1.2.3 for
+	// prefix v, and cmd/tool/v1.2.3 or v1.2.3 for prefix cmd/tool/v
+	version = strings.TrimPrefix(version, tagPrefix)
+	if i := strings.LastIndex(tagPrefix, "/"); i >= 0 {
+		version = strings.TrimPrefix(version, tagPrefix[i+1:])
+	}
+	pkg, err := newPackage(pkgstr, tagPrefix+version)
 	if err != nil {
-		return err
+		return Package{}, err
+	}
+	if tagPrefix != "" {
+		pkg.TagPrefix = tagPrefix
+		// A prefix like "cmd/tool/v" means the module is in cmd/tool
+		if i := strings.LastIndex(tagPrefix, "/"); i > 0 {
+			pkg.Dir = tagPrefix[:i]
+		}
+	}
+	return pkg, nil
+}
+
+// Update the portfile of portname to version, writing it to outfile (the
+// existing portfile by default). Returns what to print to stdout: the portfile
+// if outfile is "-", or the JSON result.
+func updateOne(portname string, version string, outfile string, opts Options, format string) ([]byte, error) {
+	toStdOut := outfile == "-"
+	pkg, portfilePath, portfileOldStr, err := existingPortfile(portname, version)
+	if err != nil {
+		return nil, err
 	}
 	tmplate, err := templateFromPortfile(pkg, portfileOldStr)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if opts.Template != "" {
+		tmplate = opts.Template
+	}
+	opts, err = optionsFromPortfile(opts, portfileOldStr)
+	if err != nil {
+		return nil, err
 	}
 	if outfile == "" {
 		outfile = portfilePath
@@ -215,22 +596,74 @@ func updateOne(portname string, version string, outfile string, lockfileDir stri
 	if !toStdOut {
 		log.Printf("Updating existing portfile: %s", portfilePath)
 	}
-	portfileNew, err := generateOne(pkg, tmplate, lockfileDir)
+	result, err := generateOne(pkg, tmplate, opts)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if !toStdOut {
+		err = os.WriteFile(outfile, []byte(result.Portfile), 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if format == "json" {
+		// The portfile itself is included in the JSON, so when writing to
+		// stdout we only emit the JSON
+		return result.json()
 	}
 	if toStdOut {
-		_, err = fmt.Print(string(portfileNew))
-	} else {
-		err = os.WriteFile(outfile, portfileNew, 0755)
+		return []byte(result.Portfile), nil
 	}
-	if err != nil {
-		return err
+	return nil, nil
+}
+
+// Fill in options not given explicitly from how an existing portfile was
+// generated
+func optionsFromPortfile(opts Options, portfile string) (Options, error) {
+	if opts.TarballFrom == "" {
+		opts.TarballFrom = tarballFromPortfile(portfile)
 	}
-	return nil
+	opts.GroupIndirect = opts.GroupIndirect || groupIndirectFromPortfile(portfile)
+	if opts.ReleaseAsset == "" {
+		asset, err := releaseAssetFromPortfile(portfile)
+		if err != nil {
+			return opts, err
+		}
+		if asset != "" {
+			version, _ := versionFromPortfile(portfile)
+			tag := tagPrefixFromPortfile(portfile) + version
+			opts.ReleaseAsset, err = releaseAssetPattern(asset, tag, version)
+			if err != nil {
+				return opts, err
+			}
+		}
+	}
+	return opts, nil
 }
 
 var setupPkgRegexp = regexp.MustCompile("go.setup\\s+(\\S+)")
+var goPackageRegexp = regexp.MustCompile("go.package\\s+(\\S+)")
+
+var setupTagPrefixRegexp = regexp.MustCompile("go.setup[ \\t]+\\S+[ \\t]+\\S+[ \\t]+([^\\s\\\\]+)")
+
+var setupVersionRegexp = regexp.MustCompile("go.setup[ \\t]+\\S+[ \\t]+([^\\s\\\\]+)")
+
+// The version given to go.setup, without any tag prefix
+func versionFromPortfile(portfile string) (string, error) {
+	match := setupVersionRegexp.FindStringSubmatch(portfile)
+	if len(match) < 2 {
+		return "", errors.New("Could not detect version in portfile")
+	}
+	return match[1], nil
+}
+
+func tagPrefixFromPortfile(portfile string) string {
+	match := setupTagPrefixRegexp.FindStringSubmatch(portfile)
+	if len(match) < 2 {
+		return ""
+	}
+	return match[1]
+}
 
 func packageFromPortfile(portfile string) (string, error) {
 	match := setupPkgRegexp.FindStringSubmatch(portfile)
@@ -240,37 +673,129 @@ func packageFromPortfile(portfile string) (string, error) {
 	return match[1], nil
 }
 
+var tarballFromPattern = regexp.MustCompile("github\\.tarball_from\\s+(\\S+)")
+
+func tarballFromPortfile(portfile string) string {
+	match := tarballFromPattern.FindStringSubmatch(portfile)
+	if len(match) < 2 {
+		return ""
+	}
+	return match[1]
+}
+
 var checksumsPattern = regexp.MustCompile("checksums(?:.*\\\\\n)*.*")
 var goVendorsPattern = regexp.MustCompile("go\\.vendors(?:.*\\\\\n)*.*")
 
+// An entry of a block such as go.vendors or checksums: a name followed by
+// keyword-value pairs
+type blockEntry struct {
+	Name   string
+	Values map[string]string
+}
+
+// Read the entries of every block matching pattern in a portfile, in order.
+// A keyword followed by a value belongs to the entry before it, or to an entry
+// with no name if it comes first; any other word starts a new entry.
+func parseKeywordBlocks(portfile string, pattern *regexp.Regexp, keywords map[string]bool) []blockEntry {
+	var ret []blockEntry
+	for _, block := range pattern.FindAllString(portfile, -1) {
+		words := strings.Fields(strings.ReplaceAll(block, "\\\n", " "))
+		var current *blockEntry
+		for i := 1; i < len(words); i++ {
+			word := words[i]
+			if !keywords[word] || i+1 >= len(words) {
+				ret = append(ret, blockEntry{Name: word, Values: map[string]string{}})
+				current = &ret[len(ret)-1]
+				continue
+			}
+			if current == nil {
+				ret = append(ret, blockEntry{Values: map[string]string{}})
+				current = &ret[len(ret)-1]
+			}
+			i++
+			current.Values[word] = words[i]
+		}
+	}
+	return ret
+}
+
+func (entry *blockEntry) checksums() Checksums {
+	return Checksums{
+		Rmd160: entry.Values["rmd160"],
+		Sha256: entry.Values["sha256"],
+		Size:   entry.Values["size"],
+	}
+}
+
+// The heading of indirect vendors and the blank lines around it, followed by
+// what's left of the go.vendors block that followed it
+var leftoverIndirectPattern = regexp.MustCompile("\n*" + regexp.QuoteMeta(indirectHeading) + "\n+(\\{\\{\\.GoVendors\\}\\})?\n*")
+
+func groupIndirectFromPortfile(portfile string) bool {
+	return strings.Contains(portfile, indirectHeading)
+}
+
 func templateFromPortfile(pkg Package, portfile string) (string, error) {
-	setupRegexp := fmt.Sprintf("(?P<before>go.setup\\s+%s\\s+)\\S+(?P<after>.*)", pkg.ResolvedId)
+	setupRegexp := fmt.Sprintf("(?P<before>go.setup\\s+%s\\s+)\\S+(?P<after>.*)", regexp.QuoteMeta(pkg.setupId()))
 	setupPattern, err := regexp.Compile(setupRegexp)
 	if err != nil {
 		return "", err
 	}
-	portfile = setupPattern.ReplaceAllString(portfile, "$before{{.Version}}$after")
-	portfile = goVendorsPattern.ReplaceAllString(portfile, "{{.GoVendors}}")
+	if tarballFromPattern.MatchString(portfile) {
+		portfile = setupPattern.ReplaceAllString(portfile, "$before{{.Version}}$after")
+		portfile = tarballFromPattern.ReplaceAllString(portfile, "{{.TarballFrom}}")
+	} else {
+		portfile = setupPattern.ReplaceAllString(portfile, "$before{{.Version}}$after{{with .TarballFrom}}\n{{.}}{{end}}")
+	}
+	// Vendors may be split into direct and indirect groups; they are
+	// regenerated as a whole in place of the first
+	first := true
+	portfile = goVendorsPattern.ReplaceAllStringFunc(portfile, func(string) string {
+		if first {
+			first = false
+			return "{{.GoVendors}}"
+		}
+		return ""
+	})
+	portfile = leftoverIndirectPattern.ReplaceAllStringFunc(portfile, func(match string) string {
+		if strings.Contains(match, "{{.GoVendors}}") {
+			return "\n\n{{.GoVendors}}\n\n"
+		}
+		return "\n\n"
+	})
 	portfile = checksumsPattern.ReplaceAllString(portfile, "{{.Checksums}}")
+	portfile = replaceDistfileLines(portfile)
 	return portfile, nil
 }
 
 type Package struct {
-	Host    string
-	Author  string
-	Project string
+	Host    string `json:"host"`
+	Author  string `json:"author"`
+	Project string `json:"project"`
 	// The original ID of the package as recorded in the lockfile, etc.
-	Id string
+	Id string `json:"id"`
 	// The resolved ID of the package, which may be different from the original
 	// e.g. when redirection services are used
-	ResolvedId string
-	Version    string
+	ResolvedId string `json:"resolvedId"`
+	Version    string `json:"version"`
+	// The subdirectory of the module within the repo, if any
+	Dir string `json:"dir,omitempty"`
+	// The part of the version tag that precedes the version proper, e.g.
+	// "cmd/tool/v" for modules in subdirectories
+	TagPrefix string `json:"tagPrefix,omitempty"`
+	// The major version suffix of the module path, e.g. "v3"
+	Major string `json:"major,omitempty"`
+	// Whether the module uses the major subdirectory layout, i.e. lives in a
+	// directory named for Major rather than on a major branch
+	MajorSubdir bool `json:"majorSubdir,omitempty"`
+	// Source of GitHub distfiles; see Options
+	TarballFrom string `json:"tarballFrom,omitempty"`
 }
 
 type Checksums struct {
-	Rmd160 string
-	Sha256 string
-	Size   string
+	Rmd160 string `json:"rmd160"`
+	Sha256 string `json:"sha256"`
+	Size   string `json:"size"`
 }
 
 // This struct represents the main information we need about a dependency
@@ -279,8 +804,10 @@ type Checksums struct {
 // well. Supporting additional formats may require refactoring to funnel various
 // format-specific structures into a single generic one.
 type Dependency struct {
-	Name    string
-	Version string `toml:"revision"`
+	Name    string `json:"name"`
+	Version string `toml:"revision" json:"version"`
+	// Whether go.mod marks the requirement // indirect
+	Indirect bool `toml:"-" yaml:"-" json:"indirect,omitempty"`
 }
 
 type GlideLock struct {
@@ -291,43 +818,273 @@ type GopkgLock struct {
 	Projects []Dependency
 }
 
-func generateOne(pkg Package, tmplate string, lockfileDir string) ([]byte, error) {
-	deps, err := dependencies(pkg, lockfileDir)
-	if debugOn && err != nil {
-		msg := fmt.Sprintf("Could not retrieve dependencies for package: %s", pkg.Id)
-		log.Println(msg)
-		log.Println(err)
+// A dependency after resolution to a concrete package and distfile
+type Vendor struct {
+	Dependency
+	Package    Package       `json:"package"`
+	TarballUrl string        `json:"tarballUrl"`
+	Checksums  Checksums     `json:"checksums"`
+	License    *LicenseMatch `json:"license,omitempty"`
+	Warnings   []string      `json:"warnings,omitempty"`
+	// What was read from the tarball, if anything
+	Source *Source `json:"-"`
+}
+
+// Everything we determined while generating a portfile. This is what is
+// emitted with --format json.
+type Result struct {
+	Package       Package       `json:"package"`
+	TarballUrl    string        `json:"tarballUrl"`
+	ReleaseAsset  string        `json:"releaseAsset,omitempty"`
+	Checksums     Checksums     `json:"checksums"`
+	Build         Build         `json:"build"`
+	DependsLib    []string      `json:"dependsLib,omitempty"`
+	License       *LicenseMatch `json:"license,omitempty"`
+	Metadata      Metadata      `json:"metadata"`
+	Categories    []string      `json:"categories,omitempty"`
+	GoRequirement GoRequirement `json:"goRequirement"`
+	Dependencies  []Vendor      `json:"dependencies"`
+	Warnings      []string      `json:"warnings"`
+	Portfile      string        `json:"portfile"`
+}
+
+func (result *Result) json() ([]byte, error) {
+	out, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// Log a warning and return it in a form suitable for Result.Warnings
+func warning(msg string, err error) string {
+	log.Println("WARNING: " + msg)
+	if err == nil {
+		return msg
 	}
+	log.Println(err)
+	return msg + ": " + err.Error()
+}
 
+func generateOne(pkg Package, tmplate string, opts Options) (Result, error) {
+	pkg.TarballFrom = opts.TarballFrom
+	detectMajorLayout(&pkg)
+	result := Result{
+		Package:      pkg,
+		Dependencies: []Vendor{},
+		Warnings:     []string{},
+	}
 	var buf bytes.Buffer
-	tplt := template.Must(template.New("portfile").Parse(tmplate))
+	tplt, err := parseTemplate(tmplate)
+	if err != nil {
+		return result, err
+	}
 
-	tarUrl, err := tarballUrlForMain(pkg)
+	var deps []Dependency
+	var tarUrl, distfile string
+	if opts.ReleaseAsset != "" {
+		// Release assets are expected to include vendored dependencies, so we
+		// don't look for any
+		asset, err := chooseReleaseAsset(pkg, opts.ReleaseAsset)
+		if err != nil {
+			return result, err
+		}
+		distfile, err = releaseAssetDistfile(pkg, asset)
+		if err != nil {
+			return result, err
+		}
+		tarUrl = asset.Url
+		result.ReleaseAsset = asset.Name
+	} else {
+		deps, err = dependencies(pkg, pkg.lockfileDir(opts.LockfileDir))
+		if debugOn && err != nil {
+			msg := fmt.Sprintf("Could not retrieve dependencies for package: %s", pkg.Id)
+			log.Println(msg)
+			log.Println(err)
+		}
+
+		if strings.Contains(pkg.TagPrefix, "/") {
+			if err := checkSubdirTag(pkg); err != nil {
+				result.Warnings = append(result.Warnings, warning("Could not verify version tag", err))
+			}
+		}
+
+		tarUrl, err = tarballUrlForMain(pkg)
+		if err != nil {
+			msg := fmt.Sprintf("Could not calculate checksums for package: %s", pkg.Id)
+			result.Warnings = append(result.Warnings, warning(msg, err))
+		}
+	}
 	if debugOn {
 		log.Printf("Resolved %s to %s", pkg.Id, tarUrl)
 	}
+	result.TarballUrl = tarUrl
+
+	result.Checksums = placeholderChecksums
+	var src *Source
+	// Without a URL there is nothing to checksum, and we have already warned
+	if tarUrl != "" {
+		csums, err := checksums(pkg.Id, tarUrl, scanMain)
+		if err != nil {
+			msg := fmt.Sprintf("Could not calculate checksums for package: %s", pkg.Id)
+			result.Warnings = append(result.Warnings, warning(msg, err))
+		}
+		result.Checksums = csums
+		if tarball, err := fetchTarball(tarUrl, scanMain); err == nil {
+			src = tarball.Source
+		}
+	}
+	build, err := newBuild(pkg, src, opts.Commands)
 	if err != nil {
-		msg := fmt.Sprintf("WARNING: Could not calculate checksums for package: %s", pkg.Id)
-		log.Println(msg)
-		log.Println(err)
+		return result, err
+	}
+	if !opts.VersionLdflags {
+		build.VersionVars = nil
+	}
+	// A goreleaser build replaces the detected one, but its -X flags are only
+	// kept with --version-ldflags, like the detected version variables
+	if cfg := fetchGoreleaser(pkg, pkg.lockfileDir(opts.LockfileDir)); cfg != nil {
+		grBuild, warnings, err := goreleaserToBuild(pkg, *cfg, opts.Commands)
+		if err == nil {
+			if !opts.VersionLdflags {
+				grBuild.Ldflags = withoutVariables(grBuild.Ldflags)
+			}
+			build = grBuild
+			for _, msg := range warnings {
+				result.Warnings = append(result.Warnings, warning(msg, nil))
+			}
+		} else if debugOn {
+			log.Printf("Not using goreleaser config: %s", err)
+		}
+	}
+	result.Build = build
+
+	// Vendor sources are only read as far as the options need
+	vendorMode := scanChecksums
+	if opts.VendorLicenses {
+		vendorMode |= scanLicenses
+	}
+	if opts.Cgo && opts.ReleaseAsset == "" {
+		vendorMode |= scanImports
+	}
+	vendors := resolveVendors(deps, vendorMode)
+	if vendors != nil {
+		result.Dependencies = vendors
+	}
+	for _, vendor := range vendors {
+		result.Warnings = append(result.Warnings, vendor.Warnings...)
+	}
+	for _, msg := range mixedRevisionWarnings(vendors) {
+		result.Warnings = append(result.Warnings, warning(msg, nil))
+	}
+	var vendorLicenses string
+	if opts.VendorLicenses {
+		for _, msg := range vendorLicenseWarnings(vendors) {
+			result.Warnings = append(result.Warnings, warning(msg, nil))
+		}
+		vendorLicenses = vendorLicensesComment(vendors)
+	}
+
+	if opts.License {
+		if src != nil {
+			result.License = src.license(pkg.srcDir())
+		}
+		if result.License == nil {
+			result.Warnings = append(result.Warnings, warning("Could not determine license", nil))
+		}
 	}
 
-	tvars := map[string]string{
-		"PackageId":    pkg.ResolvedId,
-		"PackageAlias": packageAlias(pkg),
-		"Version":      pkg.Version,
-		"Checksums":    checksumsStr(pkg.Id, tarUrl, len(deps)),
-		"GoVendors":    goVendors(deps),
+	meta := Metadata{}
+	readme := ""
+	if src != nil {
+		readme = src.readme(pkg.srcDir())
+	}
+	if opts.Metadata {
+		meta, err = forgeMetadata(pkg)
+		if err != nil {
+			msg := fmt.Sprintf("Could not retrieve metadata for package: %s", pkg.Id)
+			result.Warnings = append(result.Warnings, warning(msg, err))
+		}
+		meta = completeMetadata(pkg, meta, readme)
+	}
+	result.Metadata = meta
+	if opts.Categories {
+		result.Categories = suggestCategories(meta.Topics, readme)
+	}
+
+	var dependsBuild string
+	if modBytes, err := fetchGoMod(pkg, pkg.lockfileDir(opts.LockfileDir)); err == nil {
+		if req, err := readGoRequirement(modBytes); err == nil {
+			result.GoRequirement = req
+			var msg string
+			dependsBuild, msg = goRequirementDepends(req)
+			if msg != "" {
+				result.Warnings = append(result.Warnings, warning(msg, nil))
+			}
+		}
+	}
+
+	var dependsLib, cgoWarnings []string
+	// Release assets usually vendor their dependencies, which we don't scan
+	if opts.Cgo && opts.ReleaseAsset == "" {
+		dependsLib, cgoWarnings = applyCgo(&build, pkg, src, vendors)
+	}
+	result.Build = build
+	result.DependsLib = dependsLib
+	for _, msg := range cgoWarnings {
+		result.Warnings = append(result.Warnings, warning(msg, nil))
 	}
 
-	err = tplt.Execute(&buf, tvars)
+	// Redefinitions of the blocks in the template apply to these fields too
+	checksumsBlock, err := executeBlock(tplt, "checksums", &result)
 	if err != nil {
-		return nil, err
+		return result, err
+	}
+	goVendorsBlock, err := executeBlock(tplt, vendorsBlockName(opts.GroupIndirect), vendors)
+	if err != nil {
+		return result, err
+	}
+
+	tdata := TemplateData{
+		PackageId:        pkg.setupId(),
+		PackageAlias:     packageAlias(pkg),
+		Version:          pkg.setupVersion(),
+		TagPrefix:        pkg.TagPrefix,
+		TarballFrom:      tarballFromStr(pkg.TarballFrom),
+		Distfile:         distfile,
+		Categories:       strings.Join(result.Categories, " "),
+		Maintainers:      userConfig.Maintainer.String(),
+		MaintainerEmail:  userConfig.Maintainer.Email,
+		MaintainerGitHub: userConfig.Maintainer.GitHub,
+		License:          licenseStr(result.License),
+		LicenseNote:      licenseNote(result.License),
+		VendorLicenses:   vendorLicenses,
+		Description:      descriptionStr(meta),
+		LongDescription:  longDescriptionStr(meta),
+		Homepage:         tclEscape(meta.Homepage),
+		Checksums:        checksumsBlock,
+		GoVendors:        goVendorsBlock,
+		DependsBuild:     dependsBuild,
+		DependsLib:       dependsLibStr(dependsLib),
+		Build:            build.String(),
+		Destroot:         build.destrootStr(),
+		Result:           &result,
+		Package:          pkg,
+		Dependencies:     result.Dependencies,
+		Binaries:         build.Binaries,
+		Maintainer:       userConfig.Maintainer,
+	}
+
+	err = tplt.Execute(&buf, tdata)
+	if err != nil {
+		return result, err
 	}
-	return buf.Bytes(), nil
+	result.Portfile = buf.String()
+	return result, nil
 }
 
 var verReg = regexp.MustCompile("\\..*$")
+var majorReg = regexp.MustCompile("^v[0-9]+$")
 
 func newPackage(pkg string, version string) (Package, error) {
 	parts := strings.Split(pkg, "/")
@@ -338,16 +1095,6 @@ func newPackage(pkg string, version string) (Package, error) {
 		Version:    version,
 	}
 	dir := ""
-	// Special case for protobuf, which is a very common dependency but is
-	// canonically hosted on go.googlesource.com which can't serve stable
-	// tarballs.
-	if pkg == "google.golang.org/protobuf" {
-		ret.Host = "github.com"
-		ret.Author = "protocolbuffers"
-		ret.Project = "protobuf-go"
-		ret.ResolvedId = "github.com/protocolbuffers/protobuf-go"
-		return ret, nil
-	}
 	switch parts[0] {
 	case "golang.org":
 		if len(parts) < 3 {
@@ -375,7 +1122,7 @@ func newPackage(pkg string, version string) (Package, error) {
 		default:
 			return ret, errors.New(fmt.Sprintf("Invalid package ID: %s", pkg))
 		}
-	case "bitbucket.org":
+	case "bitbucket.org", "codeberg.org":
 		fallthrough
 	case "github.com":
 		if len(parts) < 3 {
@@ -391,6 +1138,7 @@ func newPackage(pkg string, version string) (Package, error) {
 		if err != nil {
 			return ret, err
 		}
+		parts = googlesourceMirror(parts)
 		ret.ResolvedId = strings.Join(parts, "/")
 		ret.Host = parts[0]
 		// TODO: What if there's really more than 3?
@@ -406,24 +1154,138 @@ func newPackage(pkg string, version string) (Package, error) {
 	}
 	if dir != "" {
 		parts := strings.Split(dir, "/")
-		if semver.IsValid(parts[len(parts)-1]) {
+		if last := parts[len(parts)-1]; semver.IsValid(last) {
+			if majorReg.MatchString(last) {
+				ret.Major = last
+			}
 			dir = strings.Join(parts[:len(parts)-1], "/")
 		}
 		if dir != "" && semver.IsValid(ret.Version) {
 			ret.Version = dir + "/" + ret.Version
+			ret.TagPrefix = dir + "/v"
 		}
+		ret.Dir = dir
 	}
 	return ret, nil
 }
 
+// The version as given to go.setup, which prepends TagPrefix to get the tag
+func (pkg *Package) setupVersion() string {
+	return strings.TrimPrefix(pkg.Version, pkg.TagPrefix)
+}
+
+// The module's path below the repo root as it appears in the module path,
+// e.g. "cmd/tool/v2"
+func (pkg *Package) subpath() string {
+	return path.Join(pkg.Dir, pkg.Major)
+}
+
+// The directory of the module's source within the repo
+func (pkg *Package) srcDir() string {
+	if pkg.MajorSubdir {
+		return path.Join(pkg.Dir, pkg.Major)
+	}
+	return pkg.Dir
+}
+
+func trimSubpath(id string, subpath string) string {
+	if subpath == "" {
+		return id
+	}
+	return strings.TrimSuffix(id, "/"+subpath)
+}
+
+// The ID of the repository root, as given to go.setup. For a module in a
+// subdirectory this omits the subdirectory (and any major version suffix).
+func (pkg *Package) setupId() string {
+	return trimSubpath(pkg.ResolvedId, pkg.subpath())
+}
+
+// The import path the repo root must be placed at for the module to build, as
+// given to go.package. With the major branch layout the major version suffix
+// is part of it; with the major subdirectory layout it is an actual directory.
+func (pkg *Package) goPackage() string {
+	root := trimSubpath(pkg.Id, pkg.subpath())
+	if pkg.Major != "" && !pkg.MajorSubdir && pkg.Dir == "" {
+		return root + "/" + pkg.Major
+	}
+	return root
+}
+
+// The directory of the lockfile in the repo: the one given explicitly, or else
+// the module's own directory
+func (pkg *Package) lockfileDir(explicit string) string {
+	if explicit != "" {
+		return explicit
+	}
+	if dir := pkg.srcDir(); dir != "" {
+		return dir
+	}
+	return "/"
+}
+
+// A module with a major version suffix like /v3 is developed either on a
+// branch with go.mod in the usual place (the major branch layout) or in a v3
+// subdirectory (the major subdirectory layout). Detect which one pkg uses.
+func detectMajorLayout(pkg *Package) {
+	if pkg.Major == "" {
+		return
+	}
+	modUrl, err := rawFileUrl(*pkg, path.Join(pkg.Dir, pkg.Major), "go.mod")
+	if err != nil {
+		return
+	}
+	status, body, err := fetch(modUrl)
+	if err != nil || status != 200 {
+		return
+	}
+	if strings.HasSuffix(modfile.ModulePath(body), "/"+pkg.Major) {
+		pkg.MajorSubdir = true
+	}
+	if debugOn {
+		log.Printf("Detected major subdirectory layout for %s: %t", pkg.Id, pkg.MajorSubdir)
+	}
+}
+
+// Modules in subdirectories are tagged with the subdirectory as a prefix, e.g.
+// cmd/tool/v1.2.3; make sure such a tag exists
+func checkSubdirTag(pkg Package) error {
+	modUrl, err := rawFileUrl(pkg, pkg.srcDir(), "go.mod")
+	if err != nil {
+		return err
+	}
+	status, _, err := fetch(modUrl)
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return fmt.Errorf("%s/go.mod not found at tag %s; HTTP status=%d", pkg.srcDir(), pkg.Version, status)
+	}
+	return nil
+}
+
+type resolvedPackage struct {
+	parts []string
+	dir   string
+}
+
+var resolvedPackages memo[resolvedPackage]
+
 func resolvePackage(pkg string) ([]string, string, error) {
+	resolved, err := resolvedPackages.get(pkg, func() (resolvedPackage, error) {
+		parts, dir, err := fetchPackageResolution(pkg)
+		return resolvedPackage{parts, dir}, err
+	})
+	return resolved.parts, resolved.dir, err
+}
+
+func fetchPackageResolution(pkg string) ([]string, string, error) {
 	dir := ""
-	res, err := http.Get("https://" + pkg + "?go-get=1")
+	_, body, err := fetch("https://" + pkg + "?go-get=1")
 	if err != nil {
 		return nil, dir, err
 	}
-	doc, err := html.Parse(res.Body)
-	res.Body.Close()
+	doc, err := html.Parse(bytes.NewReader(body))
 	if err != nil {
 		return nil, dir, err
 	}
@@ -484,12 +1346,35 @@ func resolvePackage(pkg string) ([]string, string, error) {
 		return false
 	}
 	if f(doc) {
+		detectGoSource(doc, parts[0])
 		return parts, dir, nil
 	} else {
 		return nil, dir, errors.New(fmt.Sprintf("Invalid package ID: %s", pkg))
 	}
 }
 
+// Look for go-source metadata that identifies the forge serving host
+func detectGoSource(n *html.Node, host string) {
+	if n.Type == html.ElementNode && n.Data == "meta" {
+		isGoSource := false
+		content := ""
+		for _, a := range n.Attr {
+			if a.Key == "name" && a.Val == "go-source" {
+				isGoSource = true
+			} else if a.Key == "content" {
+				content = a.Val
+			}
+		}
+		if isGoSource && isGiteaGoSource(content) {
+			markGiteaHost(host)
+		}
+		return
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		detectGoSource(c, host)
+	}
+}
+
 func dependencies(pkg Package, lockfileDir string) ([]Dependency, error) {
 	deps, err := moduleDependencies(pkg, lockfileDir)
 	if err == nil {
@@ -525,28 +1410,40 @@ func rawFileUrl(pkg Package, dir string, file string) (string, error) {
 		return fmt.Sprintf("https://gitlab.com/%s/%s/-/raw/%s/%s/%s",
 			pkg.Author, pkg.Project, pkg.Version, dir, file), nil
 	default:
+		if isGitea(pkg.Host) {
+			return fmt.Sprintf("https://%s/%s/%s/raw/%s/%s/%s",
+				pkg.Host, pkg.Author, pkg.Project, pkg.Version, dir, file), nil
+		}
 		return "", errors.New(fmt.Sprintf("Unsupported domain: %s", pkg.Host))
 	}
 }
 
-func moduleDependencies(pkg Package, lockfileDir string) ([]Dependency, error) {
-	modUrl, err := rawFileUrl(pkg, lockfileDir, "go.mod")
+var goMods memo[[]byte]
+
+// Download the go.mod in dir, once per URL
+func fetchGoMod(pkg Package, dir string) ([]byte, error) {
+	modUrl, err := rawFileUrl(pkg, dir, "go.mod")
 	if debugOn {
 		log.Printf("Looking for go.mod at %s", modUrl)
 	}
 	if err != nil {
 		return nil, err
 	}
-	res, err := http.Get(modUrl)
-	if err != nil {
-		return nil, err
-	}
-	if res.StatusCode != 200 {
-		msg := fmt.Sprintf("go.mod not available; HTTP status=%d", res.StatusCode)
-		return nil, errors.New(msg)
-	}
-	modBytes, err := io.ReadAll(res.Body)
-	res.Body.Close()
+	return goMods.get(modUrl, func() ([]byte, error) {
+		status, modBytes, err := fetch(modUrl)
+		if err != nil {
+			return nil, err
+		}
+		if status != 200 {
+			msg := fmt.Sprintf("go.mod not available; HTTP status=%d", status)
+			return nil, errors.New(msg)
+		}
+		return modBytes, nil
+	})
+}
+
+func moduleDependencies(pkg Package, lockfileDir string) ([]Dependency, error) {
+	modBytes, err := fetchGoMod(pkg, lockfileDir)
 	if err != nil {
 		return nil, err
 	}
@@ -574,7 +1471,7 @@ func readGoMod(data []byte) ([]Dependency, error) {
 		} else {
 			version = semver.Canonical(version)
 		}
-		mods[name] = Dependency{Name: name, Version: version}
+		mods[name] = Dependency{Name: name, Version: version, Indirect: req.Indirect}
 	}
 
 	pkgs := make([]string, 0, len(mods))
@@ -608,19 +1505,14 @@ func glideDependencies(pkg Package, lockfileDir string) ([]Dependency, error) {
 	if debugOn {
 		log.Printf("Looking for glide.lock at %s", lockUrl)
 	}
-	res, err := http.Get(lockUrl)
+	status, lockBytes, err := fetch(lockUrl)
 	if err != nil {
 		return nil, err
 	}
-	if res.StatusCode != 200 {
-		msg := fmt.Sprintf("glide.lock not available; HTTP status=%d", res.StatusCode)
+	if status != 200 {
+		msg := fmt.Sprintf("glide.lock not available; HTTP status=%d", status)
 		return nil, errors.New(msg)
 	}
-	lockBytes, err := io.ReadAll(res.Body)
-	res.Body.Close()
-	if err != nil {
-		return nil, err
-	}
 	lock := GlideLock{}
 	err = yaml.Unmarshal(lockBytes, &lock)
 	if err != nil {
@@ -637,19 +1529,14 @@ func gopkgDependencies(pkg Package, lockfileDir string) ([]Dependency, error) {
 	if debugOn {
 		log.Printf("Looking for Gopkg.lock at %s", lockUrl)
 	}
-	res, err := http.Get(lockUrl)
+	status, lockBytes, err := fetch(lockUrl)
 	if err != nil {
 		return nil, err
 	}
-	if res.StatusCode != 200 {
-		msg := fmt.Sprintf("Gopkg.lock not available; HTTP status=%d", res.StatusCode)
+	if status != 200 {
+		msg := fmt.Sprintf("Gopkg.lock not available; HTTP status=%d", status)
 		return nil, errors.New(msg)
 	}
-	lockBytes, err := io.ReadAll(res.Body)
-	res.Body.Close()
-	if err != nil {
-		return nil, err
-	}
 	lock := GopkgLock{}
 	err = toml.Unmarshal(lockBytes, &lock)
 	if err != nil {
@@ -666,19 +1553,14 @@ func glockDependencies(pkg Package, lockfileDir string) ([]Dependency, error) {
 	if debugOn {
 		log.Printf("Looking for GLOCKFILE at %s", lockUrl)
 	}
-	res, err := http.Get(lockUrl)
+	status, glockBytes, err := fetch(lockUrl)
 	if err != nil {
 		return nil, err
 	}
-	if res.StatusCode != 200 {
-		msg := fmt.Sprintf("GLOCKFILE not available; HTTP status=%d", res.StatusCode)
+	if status != 200 {
+		msg := fmt.Sprintf("GLOCKFILE not available; HTTP status=%d", status)
 		return nil, errors.New(msg)
 	}
-	glockBytes, err := io.ReadAll(res.Body)
-	res.Body.Close()
-	if err != nil {
-		return nil, err
-	}
 	return readGlockfile(glockBytes), nil
 }
 
@@ -705,89 +1587,160 @@ func readGlockfile(data []byte) []Dependency {
 }
 
 func packageAlias(pkg Package) string {
-	if pkg.Id == pkg.ResolvedId {
+	goPackage := pkg.goPackage()
+	if goPackage == pkg.setupId() {
 		return ""
 	}
-	return fmt.Sprintf("go.package%s%s\n\n", strings.Repeat(" ", 10), pkg.Id)
+	return fmt.Sprintf("go.package%s%s\n\n", strings.Repeat(" ", 10), goPackage)
 }
 
-func goVendor(dep Dependency) (string, error) {
-	ret := ""
+func resolveVendor(dep Dependency) Vendor {
 	pkg, err := newPackage(dep.Name, dep.Version)
-	ret = ret + pkg.Id + " \\\n"
-	if pkg.Id != pkg.ResolvedId {
-		ret = ret + fmt.Sprintf("%srepo    %s \\\n", strings.Repeat(" ", 24), pkg.ResolvedId)
-	}
-	ret = ret + fmt.Sprintf("%slock    %s \\\n", strings.Repeat(" ", 24), pkg.Version)
+	vendor := Vendor{Dependency: dep, Package: pkg}
 	if debugOn && err != nil {
 		msg := fmt.Sprintf("Could not parse package ID: %s", dep.Name)
 		log.Println(msg)
 		log.Println(err)
 	}
-	if debugOn {
-		log.Printf("Calculating checksums for %s", pkg.Id)
-	}
 	tarUrl, err := tarballUrlForVendors(pkg)
 	if debugOn {
 		log.Printf("Resolved %s to %s", pkg.Id, tarUrl)
 	}
 	if err != nil {
-		msg := fmt.Sprintf("WARNING: Could not get tarball URL for package: %s", pkg.Id)
-		log.Println(msg)
-		log.Println(err)
+		msg := fmt.Sprintf("Could not get tarball URL for package: %s", pkg.Id)
+		vendor.Warnings = append(vendor.Warnings, warning(msg, err))
 	}
-	csums, err := checksums(pkg.Id, tarUrl)
-	if err != nil {
-		msg := fmt.Sprintf("WARNING: Could not calculate checksums for package: %s", pkg.Id)
-		log.Println(msg)
-		log.Println(err)
-	}
-	ret = ret + csums.valueString(24)
-	return ret, nil
+	vendor.TarballUrl = tarUrl
+	return vendor
+}
+
+// The repository a package is distributed in, independent of the module's
+// subdirectory within it
+func (pkg *Package) repo() string {
+	return strings.Join([]string{pkg.Host, pkg.Author, pkg.Project}, "/")
 }
 
-func goVendors(deps []Dependency) string {
+// Resolve and checksum deps, reading their sources as far as mode asks
+func resolveVendors(deps []Dependency, mode scanMode) []Vendor {
 	if len(deps) == 0 {
-		return ""
+		return nil
 	}
-	ret := "go.vendors          "
 
+	// Downloads are limited globally by fetch, so no limit is needed here
 	var g errgroup.Group
-	g.SetLimit(8)
-	results := make([]string, len(deps))
+	results := make([]Vendor, len(deps))
 
+	// Vendors that are the same repo at the same revision, as is common with
+	// multi-module repos like google-cloud-go, share a tarball URL, so
+	// fetchTarball only downloads it once
 	for i, dep := range deps {
 		i, dep := i, dep
 		g.Go(func() error {
-			r, err := goVendor(dep)
-			results[i] = r
-			return err
+			vendor := resolveVendor(dep)
+			vendor.Checksums = placeholderChecksums
+			// Where the module is within its tarball only matters when its
+			// source is read
+			if mode != scanChecksums {
+				detectMajorLayout(&vendor.Package)
+			}
+			// Without a URL there is nothing to checksum, and resolveVendor
+			// has already warned
+			if vendor.TarballUrl != "" {
+				if debugOn {
+					log.Printf("Calculating checksums for %s", vendor.Package.Id)
+				}
+				csums, err := checksums(vendor.Package.Id, vendor.TarballUrl, mode)
+				if err != nil {
+					msg := fmt.Sprintf("Could not calculate checksums for package: %s", vendor.Package.Id)
+					vendor.Warnings = append(vendor.Warnings, warning(msg, err))
+				}
+				vendor.Checksums = csums
+				if tarball, err := fetchTarball(vendor.TarballUrl, mode); err == nil && tarball.Source != nil {
+					vendor.Source = tarball.Source
+					if mode&scanLicenses != 0 {
+						vendor.License = tarball.Source.license(vendor.Package.srcDir())
+					}
+				}
+			}
+			results[i] = vendor
+			return nil
 		})
 	}
 
 	_ = g.Wait()
 
-	for i, r := range results {
-		ret = ret + r
-		if i < len(deps)-1 {
-			ret = ret + " \\\n" + strings.Repeat(" ", 20)
+	return results
+}
+
+// Warn about vendors that come from the same repo at different revisions, as
+// each revision is a separate download of the whole repo
+func mixedRevisionWarnings(vendors []Vendor) []string {
+	var repos []string
+	entries := make(map[string][]string)
+	versions := make(map[string]map[string]bool)
+	for _, vendor := range vendors {
+		repo := vendor.Package.repo()
+		if _, ok := versions[repo]; !ok {
+			repos = append(repos, repo)
+			versions[repo] = make(map[string]bool)
+		}
+		versions[repo][vendor.Package.Version] = true
+		entry := fmt.Sprintf("%s (%s)", vendor.Package.Id, vendor.Package.Version)
+		entries[repo] = append(entries[repo], entry)
+	}
+	var ret []string
+	for _, repo := range repos {
+		if len(versions[repo]) > 1 {
+			msg := fmt.Sprintf("Multiple revisions of %s are vendored: %s", repo, strings.Join(entries[repo], ", "))
+			ret = append(ret, msg)
 		}
 	}
-
 	return ret
 }
 
-// Using github.tarball_from archive now for the main distfile
+const (
+	tarballFromArchive  = "archive"
+	tarballFromTarball  = "tarball"
+	tarballFromReleases = "releases"
+)
+
+func checkTarballFrom(tarballFrom string) error {
+	switch tarballFrom {
+	case "", tarballFromArchive, tarballFromTarball, tarballFromReleases:
+		return nil
+	default:
+		return fmt.Errorf("Unsupported tarball source: %s", tarballFrom)
+	}
+}
+
+func tarballFromStr(tarballFrom string) string {
+	if tarballFrom == "" {
+		return ""
+	}
+	return "github.tarball_from " + tarballFrom
+}
+
+// The PortGroup's default is github.tarball_from archive for the main distfile
 func tarballUrlForMain(pkg Package) (string, error) {
-	switch pkg.Host {
-	case "github.com":
+	if pkg.Host != "github.com" {
+		return tarballUrlForVendors(pkg)
+	}
+	switch pkg.TarballFrom {
+	case "", tarballFromArchive:
 		return fmt.Sprintf("https://github.com/%s/%s/archive/%s/dummy.tar.gz", pkg.Author, pkg.Project, pkg.Version), nil
+	case tarballFromReleases:
+		return fmt.Sprintf("https://github.com/%s/%s/releases/download/%s/%s-%s.tar.gz",
+			pkg.Author, pkg.Project, pkg.Version, pkg.Project, pkg.Version), nil
 	default:
 		return tarballUrlForVendors(pkg)
 	}
 }
 
-// TODO(aaron): Move to GitHub archive tarball for vendors too
+// The URL the PortGroup fetches a go.vendors entry from. For GitHub this is
+// always the tarball endpoint: the PortGroup hard-codes it for vendors, and
+// github.tarball_from only applies to the main distfile, so there is no
+// strategy to choose here. Checksumming vendors from the archive endpoint
+// instead would give checksums that don't match what MacPorts downloads.
 func tarballUrlForVendors(pkg Package) (string, error) {
 	switch pkg.Host {
 	case "github.com":
@@ -799,77 +1752,37 @@ func tarballUrlForVendors(pkg Package) (string, error) {
 	case "git.sr.ht":
 		return fmt.Sprintf("https://git.sr.ht/%s/%s/archive/%s.tar.gz",
 			pkg.Author, pkg.Project, pkg.Version), nil
-
-	case "go.googlesource.com":
-		// go.googlesource.com appears to serve slightly different tarballs each
-		// time you hit the URL, so it's impossible to get a stable checksum.
-		// DON'T enable until this issue has been resolved.
-		//
-		// 	return fmt.Sprintf("https://go.googlesource.com/%s/+archive/refs/tags/%s.tar.gz",
-		// 		pkg.Project, pkg.Version), nil
-		return "", errors.New("go.googlesource.com is not supported; manually resolve to a mirror")
 	default:
+		if strings.HasSuffix(pkg.Host, ".googlesource.com") {
+			// googlesource.com appears to serve slightly different tarballs
+			// each time you hit the URL, so it's impossible to get a stable
+			// checksum. DON'T enable until this issue has been resolved.
+			//
+			// 	return fmt.Sprintf("https://go.googlesource.com/%s/+archive/refs/tags/%s.tar.gz",
+			// 		pkg.Project, pkg.Version), nil
+			return "", googlesourceError(pkg)
+		}
+		if isGitea(pkg.Host) {
+			return fmt.Sprintf("https://%s/%s/%s/archive/%s.tar.gz",
+				pkg.Host, pkg.Author, pkg.Project, pkg.Version), nil
+		}
 		// Custom domain GitLab repos
 		return fmt.Sprintf("https://%s/%s/%s/-/archive/%s/%s-%s.tar.gz",
 			pkg.Host, pkg.Author, pkg.Project, pkg.Version, pkg.Project, pkg.Version), nil
 	}
 }
 
-func checksums(pkgId string, tarballUrl string) (Checksums, error) {
-	ret := Checksums{
-		Rmd160: "0",
-		Sha256: "0",
-		Size:   "0",
-	}
-	res, err := http.Get(tarballUrl)
+func checksums(pkgId string, tarballUrl string, mode scanMode) (Checksums, error) {
+	tarball, err := fetchTarball(tarballUrl, mode)
 	if err != nil {
-		return ret, err
+		return tarball.Checksums, err
 	}
-	if (res.StatusCode) != 200 {
-		msg := fmt.Sprintf("Could not retrieve tarball for %s; HTTP status=%d\nExpected at: %s", pkgId, res.StatusCode, tarballUrl)
-		return ret, errors.New(msg)
+	if tarball.Status != 200 {
+		msg := fmt.Sprintf("Could not retrieve tarball for %s; HTTP status=%d\nExpected at: %s", pkgId, tarball.Status, tarballUrl)
+		return tarball.Checksums, errors.New(msg)
 	}
-	tarball, err := io.ReadAll(res.Body)
-	res.Body.Close()
-	if err != nil {
-		return ret, err
-	}
-
-	size := len(tarball)
-	if size == 14 {
+	if tarball.Checksums.Size == "14" {
 		log.Printf("WARNING: Suspicious tarball size for %s", pkgId)
 	}
-	ret.Size = fmt.Sprintf("%d", size)
-
-	sha := sha256.New()
-	sha.Write(tarball)
-	ret.Sha256 = fmt.Sprintf("%x", sha.Sum(nil))
-
-	rmd := ripemd160.New()
-	rmd.Write(tarball)
-	ret.Rmd160 = fmt.Sprintf("%x", rmd.Sum(nil))
-
-	return ret, nil
-}
-
-func (csums *Checksums) valueString(indentSize int) string {
-	pad := strings.Repeat(" ", indentSize)
-	ret := fmt.Sprintf(`%[1]srmd160  %[2]s \
-%[1]ssha256  %[3]s \
-%[1]ssize    %[4]s`, pad, csums.Rmd160, csums.Sha256, csums.Size)
-	return ret
-}
-
-func checksumsStr(pkgId string, tarballUrl string, depCount int) string {
-	csums, err := checksums(pkgId, tarballUrl)
-	if debugOn && err != nil {
-		msg := fmt.Sprintf("Could not calculate checksums for package: %s", pkgId)
-		log.Println(msg)
-		log.Println(err)
-	}
-	if depCount > 0 {
-		return "checksums           ${distname}${extract.suffix} \\\n" + csums.valueString(24)
-	} else {
-		return "checksums           " + strings.TrimSpace(csums.valueString(20))
-	}
+	return tarball.Checksums, nil
 }
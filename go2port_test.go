@@ -1,9 +1,22 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"text/template"
+
+	"github.com/urfave/cli"
 )
 
+func goVendors(deps []Dependency) string {
+	return vendorsString(resolveVendors(deps, scanChecksums))
+}
+
 func TestGoMod(t *testing.T) {
 	goMod := []byte(`
 module example.com/foo
@@ -83,3 +96,678 @@ require (
 		t.Fatalf("unexpected output:\n--- got ---\n%s\n--- want ---\n%s", out, expected)
 	}
 }
+
+func TestMixedRevisionWarnings(t *testing.T) {
+	vendor := func(id string, author string, project string, version string) Vendor {
+		return Vendor{Package: Package{Host: "github.com", Author: author, Project: project, Id: id, Version: version}}
+	}
+	vendors := []Vendor{
+		vendor("cloud.google.com/go/storage", "googleapis", "google-cloud-go", "storage/v1.30.0"),
+		vendor("github.com/foo/bar", "foo", "bar", "v1.0.0"),
+		vendor("cloud.google.com/go/compute/metadata", "googleapis", "google-cloud-go", "compute/metadata/v0.9.0"),
+		vendor("github.com/foo/bar/baz", "foo", "bar", "v1.0.0"),
+	}
+	warnings := mixedRevisionWarnings(vendors)
+	expected := "Multiple revisions of github.com/googleapis/google-cloud-go are vendored: " +
+		"cloud.google.com/go/storage (storage/v1.30.0), " +
+		"cloud.google.com/go/compute/metadata (compute/metadata/v0.9.0)"
+	if len(warnings) != 1 || warnings[0] != expected {
+		t.Fatalf("unexpected warnings: %q", warnings)
+	}
+}
+
+func TestGiteaUrls(t *testing.T) {
+	pkg, err := newPackage("codeberg.org/foo/bar", "v1.2.3")
+	if err != nil {
+		t.Fatalf("newPackage failed: %v", err)
+	}
+	raw, err := rawFileUrl(pkg, "sub", "go.mod")
+	if err != nil {
+		t.Fatalf("rawFileUrl failed: %v", err)
+	}
+	if raw != "https://codeberg.org/foo/bar/raw/v1.2.3/sub/go.mod" {
+		t.Errorf("unexpected raw file URL: %s", raw)
+	}
+	tarball, err := tarballUrlForVendors(pkg)
+	if err != nil {
+		t.Fatalf("tarballUrlForVendors failed: %v", err)
+	}
+	if tarball != "https://codeberg.org/foo/bar/archive/v1.2.3.tar.gz" {
+		t.Errorf("unexpected tarball URL: %s", tarball)
+	}
+}
+
+func TestGiteaGoSource(t *testing.T) {
+	gitea := "git.example.com/foo/bar https://git.example.com/foo/bar https://git.example.com/foo/bar/src/branch/main{/dir} https://git.example.com/foo/bar/src/branch/main{/dir}/{file}#L{line}"
+	if !isGiteaGoSource(gitea) {
+		t.Errorf("failed to detect Gitea go-source: %s", gitea)
+	}
+	github := "github.com/foo/bar https://github.com/foo/bar https://github.com/foo/bar/tree/master{/dir} https://github.com/foo/bar/blob/master{/dir}/{file}#L{line}"
+	if isGiteaGoSource(github) {
+		t.Errorf("misdetected GitHub go-source as Gitea: %s", github)
+	}
+}
+
+func TestGooglesourceMirror(t *testing.T) {
+	cases := map[string]string{
+		"go.googlesource.com/net":               "github.com/golang/net",
+		"go.googlesource.com/protobuf":          "github.com/protocolbuffers/protobuf-go",
+		"code.googlesource.com/gocloud":         "github.com/googleapis/google-cloud-go",
+		"github.com/foo/bar":                    "github.com/foo/bar",
+		"example.googlesource.com/unknown/repo": "example.googlesource.com/unknown/repo",
+		// Only known mirrors are used
+		"go.googlesource.com/unmirrored": "go.googlesource.com/unmirrored",
+	}
+	for in, expected := range cases {
+		out := strings.Join(googlesourceMirror(strings.Split(in, "/")), "/")
+		if out != expected {
+			t.Errorf("googlesourceMirror(%s) = %s; expected %s", in, out, expected)
+		}
+	}
+}
+
+func TestGooglesourceError(t *testing.T) {
+	pkg := Package{Id: "example.com/foo", ResolvedId: "foo.googlesource.com/foo", Version: "v1.0.0"}
+	msg := googlesourceError(pkg).Error()
+	if !strings.Contains(msg, "repo    <mirror>") || !strings.Contains(msg, "lock    v1.0.0") {
+		t.Errorf("unexpected error: %s", msg)
+	}
+
+	// A known mirror is suggested as is
+	pkg = Package{Id: "example.com/net", ResolvedId: "go.googlesource.com/net", Version: "v1.0.0"}
+	msg = googlesourceError(pkg).Error()
+	if !strings.Contains(msg, "repo    github.com/golang/net \\\n") {
+		t.Errorf("expected the mirror to be suggested: %s", msg)
+	}
+}
+
+func renderTemplate(t *testing.T, tmplate string, tvars map[string]string) string {
+	var buf strings.Builder
+	err := template.Must(template.New("test").Parse(tmplate)).Execute(&buf, tvars)
+	if err != nil {
+		t.Fatalf("template execution failed: %v", err)
+	}
+	return buf.String()
+}
+
+func TestTemplateFromPortfileTarballFrom(t *testing.T) {
+	pkg := Package{Id: "github.com/foo/bar", ResolvedId: "github.com/foo/bar"}
+	tvars := map[string]string{"Version": "1.1.0", "TarballFrom": "github.tarball_from releases"}
+
+	tmplate, err := templateFromPortfile(pkg, "go.setup            github.com/foo/bar 1.0.0\ncategories\n")
+	if err != nil {
+		t.Fatalf("templateFromPortfile failed: %v", err)
+	}
+	out := renderTemplate(t, tmplate, tvars)
+	expected := "go.setup            github.com/foo/bar 1.1.0\ngithub.tarball_from releases\ncategories\n"
+	if out != expected {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+
+	tmplate, err = templateFromPortfile(pkg, "go.setup            github.com/foo/bar 1.0.0\ngithub.tarball_from tarball\ncategories\n")
+	if err != nil {
+		t.Fatalf("templateFromPortfile failed: %v", err)
+	}
+	out = renderTemplate(t, tmplate, tvars)
+	if out != expected {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestTarballFrom(t *testing.T) {
+	pkg := Package{Host: "github.com", Author: "foo", Project: "bar", Version: "v1.0.0"}
+	cases := map[string]string{
+		"":         "https://github.com/foo/bar/archive/v1.0.0/dummy.tar.gz",
+		"archive":  "https://github.com/foo/bar/archive/v1.0.0/dummy.tar.gz",
+		"tarball":  "https://github.com/foo/bar/tarball/v1.0.0",
+		"releases": "https://github.com/foo/bar/releases/download/v1.0.0/bar-v1.0.0.tar.gz",
+	}
+	for tarballFrom, expected := range cases {
+		pkg.TarballFrom = tarballFrom
+		if main, _ := tarballUrlForMain(pkg); main != expected {
+			t.Errorf("main tarball for %q: %s", tarballFrom, main)
+		}
+	}
+}
+
+func TestVendorTarballUrl(t *testing.T) {
+	// The PortGroup fetches GitHub vendors from the tarball endpoint, whatever
+	// the port's github.tarball_from
+	for _, tarballFrom := range []string{"", "archive", "tarball", "releases"} {
+		pkg := Package{Host: "github.com", Author: "foo", Project: "bar", Version: "v1.0.0", TarballFrom: tarballFrom}
+		if url, _ := tarballUrlForVendors(pkg); url != "https://github.com/foo/bar/tarball/v1.0.0" {
+			t.Errorf("vendor tarball with tarball_from %q: %s", tarballFrom, url)
+		}
+	}
+}
+
+func TestSubdirectoryModule(t *testing.T) {
+	cases := []struct {
+		id, version                                string
+		setupId, setupVersion, tagPrefix, lockfile string
+	}{
+		{"github.com/x/y/cmd/tool", "v1.2.3", "github.com/x/y", "1.2.3", "cmd/tool/v", "cmd/tool"},
+		{"github.com/x/y/cmd/tool/v2", "v2.0.1", "github.com/x/y", "2.0.1", "cmd/tool/v", "cmd/tool"},
+		{"golang.org/x/tools/gopls", "v0.14.0", "golang.org/x/tools", "0.14.0", "gopls/v", "gopls"},
+		{"github.com/x/y/cmd/tool", "abcdef0", "github.com/x/y", "abcdef0", "", "cmd/tool"},
+		{"github.com/x/y", "v1.0.0", "github.com/x/y", "v1.0.0", "", "/"},
+	}
+	for _, c := range cases {
+		pkg, err := newPackage(c.id, c.version)
+		if err != nil {
+			t.Fatalf("newPackage(%s) failed: %v", c.id, err)
+		}
+		if pkg.setupId() != c.setupId {
+			t.Errorf("%s: setupId = %s", c.id, pkg.setupId())
+		}
+		if pkg.setupVersion() != c.setupVersion {
+			t.Errorf("%s: setupVersion = %s", c.id, pkg.setupVersion())
+		}
+		if pkg.TagPrefix != c.tagPrefix {
+			t.Errorf("%s: TagPrefix = %s", c.id, pkg.TagPrefix)
+		}
+		if pkg.lockfileDir("") != c.lockfile {
+			t.Errorf("%s: lockfileDir = %s", c.id, pkg.lockfileDir(""))
+		}
+	}
+}
+
+func TestTagPrefixFromPortfile(t *testing.T) {
+	cases := map[string]string{
+		"go.setup            github.com/x/y 1.2.3 cmd/tool/v\n": "cmd/tool/v",
+		"go.setup            github.com/x/y 1.2.3 v\n":          "v",
+		"go.setup            github.com/x/y 1.2.3\nversion 1\n": "",
+	}
+	for portfile, expected := range cases {
+		if out := tagPrefixFromPortfile(portfile); out != expected {
+			t.Errorf("tagPrefixFromPortfile(%q) = %q; expected %q", portfile, out, expected)
+		}
+	}
+}
+
+func TestPortfilePackageVersion(t *testing.T) {
+	cases := []struct {
+		portfile, version, expected string
+	}{
+		{"go.setup            github.com/x/y 1.2.3 v\n", "1.3.0", "v1.3.0"},
+		{"go.setup            github.com/x/y 1.2.3 v\n", "v1.3.0", "v1.3.0"},
+		{"go.setup            github.com/x/y 1.2.3 cmd/tool/v\n", "1.3.0", "cmd/tool/v1.3.0"},
+		{"go.setup            github.com/x/y 1.2.3 cmd/tool/v\n", "v1.3.0", "cmd/tool/v1.3.0"},
+		{"go.setup            github.com/x/y 1.2.3 cmd/tool/v\n", "cmd/tool/v1.3.0", "cmd/tool/v1.3.0"},
+		{"go.setup            github.com/x/y 1.2.3\n", "1.3.0", "1.3.0"},
+	}
+	for _, c := range cases {
+		pkg, err := portfilePackage("Portfile", c.portfile, c.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkg.Version != c.expected {
+			t.Errorf("%q at %s: got %s; expected %s", c.portfile, c.version, pkg.Version, c.expected)
+		}
+	}
+}
+
+func TestMajorVersionLayout(t *testing.T) {
+	pkg, err := newPackage("github.com/foo/bar/v3", "v3.1.0")
+	if err != nil {
+		t.Fatalf("newPackage failed: %v", err)
+	}
+	if pkg.Major != "v3" || pkg.Version != "v3.1.0" {
+		t.Fatalf("unexpected package: %+v", pkg)
+	}
+
+	// Major branch layout: go.mod at the root, repo placed at the module path
+	if pkg.setupId() != "github.com/foo/bar" {
+		t.Errorf("branch: setupId = %s", pkg.setupId())
+	}
+	if alias := packageAlias(pkg); alias != "go.package          github.com/foo/bar/v3\n\n" {
+		t.Errorf("branch: packageAlias = %q", alias)
+	}
+	if pkg.srcDir() != "" || pkg.lockfileDir("") != "/" {
+		t.Errorf("branch: srcDir = %s, lockfileDir = %s", pkg.srcDir(), pkg.lockfileDir(""))
+	}
+
+	// Major subdirectory layout: go.mod in v3, repo placed at the root path
+	pkg.MajorSubdir = true
+	if alias := packageAlias(pkg); alias != "" {
+		t.Errorf("subdirectory: packageAlias = %q", alias)
+	}
+	if pkg.srcDir() != "v3" || pkg.lockfileDir("") != "v3" {
+		t.Errorf("subdirectory: srcDir = %s, lockfileDir = %s", pkg.srcDir(), pkg.lockfileDir(""))
+	}
+}
+
+func TestIndirectVendors(t *testing.T) {
+	deps, err := readGoMod([]byte(`module example.com/foo
+
+require (
+	github.com/a/direct v1.0.0
+	github.com/b/indirect v1.0.0 // indirect
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 2 || deps[0].Name != "github.com/b/indirect" || !deps[0].Indirect || deps[1].Indirect {
+		t.Fatalf("unexpected dependencies: %+v", deps)
+	}
+
+	vendors := make([]Vendor, len(deps))
+	for i, dep := range deps {
+		vendors[i] = Vendor{Dependency: dep, Package: Package{Id: dep.Name, ResolvedId: dep.Name, Version: dep.Version}}
+	}
+	grouped := vendorsBlock(vendors, true)
+	expected := `go.vendors          github.com/a/direct \
+                        lock    v1.0.0 \
+                        rmd160   \
+                        sha256   \
+                        size    
+
+# Indirect dependencies
+go.vendors          github.com/b/indirect \
+                        lock    v1.0.0 \
+                        rmd160   \
+                        sha256   \
+                        size    `
+	if grouped != expected {
+		t.Errorf("unexpected grouped vendors:\n%s", grouped)
+	}
+
+	// Updating replaces both groups
+	pkg := Package{Id: "example.com/foo", ResolvedId: "example.com/foo"}
+	portfile := "go.setup            example.com/foo 1.0.0\n\n" + grouped + "\n\nbuild.args          foo\n"
+	tmplate, err := templateFromPortfile(pkg, portfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !groupIndirectFromPortfile(portfile) {
+		t.Errorf("expected grouping to be detected")
+	}
+	expected = "go.setup            example.com/foo {{.Version}}{{with .TarballFrom}}\n{{.}}{{end}}\n\n{{.GoVendors}}\n\nbuild.args          foo\n"
+	if tmplate != expected {
+		t.Errorf("unexpected template:\n%q", tmplate)
+	}
+
+	// Only indirect dependencies
+	portfile = "go.setup            example.com/foo 1.0.0\n\n" + vendorsBlock(vendors[:1], true) + "\n\nbuild.args          foo\n"
+	tmplate, err = templateFromPortfile(pkg, portfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmplate != expected {
+		t.Errorf("unexpected template:\n%q", tmplate)
+	}
+}
+
+// Make the main tarball and go.mod of pkg available without downloading them
+func seedPackage(pkg Package, goMod string, src *Source) {
+	tarUrl, _ := tarballUrlForMain(pkg)
+	tarballs.get(tarballKey(tarUrl, scanMain), func() (Tarball, error) {
+		return Tarball{Status: 200, Checksums: Checksums{Rmd160: "r", Sha256: "s", Size: "1"}, Source: src}, nil
+	})
+	modUrl, _ := rawFileUrl(pkg, pkg.lockfileDir(""), "go.mod")
+	goMods.get(modUrl, func() ([]byte, error) { return []byte(goMod), nil })
+}
+
+func TestCheckFormat(t *testing.T) {
+	for _, format := range []string{"portfile", "json"} {
+		if err := checkFormat(format); err != nil {
+			t.Errorf("%s: %s", format, err)
+		}
+	}
+	if err := checkFormat("yaml"); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+}
+
+func TestResultJson(t *testing.T) {
+	result := Result{
+		Package:   Package{Id: "github.com/foo/bar", Version: "v1.0.0"},
+		Checksums: Checksums{Rmd160: "r", Sha256: "s", Size: "1"},
+		Warnings:  []string{"careful"},
+		Portfile:  "PortSystem 1.0\n",
+	}
+	out, err := result.json()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(out), "}\n") {
+		t.Errorf("expected a trailing newline: %q", out)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"package", "tarballUrl", "checksums", "dependencies", "warnings", "portfile"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("missing key %s in %s", key, out)
+		}
+	}
+	if _, ok := decoded["releaseAsset"]; ok {
+		t.Errorf("empty releaseAsset should be omitted: %s", out)
+	}
+}
+
+func TestGenerateJson(t *testing.T) {
+	fakeHttp(t, nil)
+	pkg, err := newPackage("github.com/example/jsontool", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedPackage(pkg, "module github.com/example/jsontool\n\ngo 1.21\n", nil)
+	out, err := generateOutput("github.com/example/jsontool", "v1.0.0", Options{}, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result Result
+	if err := json.Unmarshal(out, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Package.Id != "github.com/example/jsontool" || result.Checksums.Sha256 != "s" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if result.TarballUrl != "https://github.com/example/jsontool/archive/v1.0.0/dummy.tar.gz" {
+		t.Errorf("unexpected tarball URL: %s", result.TarballUrl)
+	}
+	if !strings.Contains(result.Portfile, "go.setup            github.com/example/jsontool v1.0.0\n") ||
+		!strings.Contains(result.Portfile, "sha256  s") {
+		t.Errorf("unexpected portfile:\n%s", result.Portfile)
+	}
+}
+
+func TestGenerateWithoutTarballUrl(t *testing.T) {
+	fakeHttp(t, nil)
+	pkg := Package{Host: "go.googlesource.com", Project: "foo", Id: "go.googlesource.com/foo", ResolvedId: "go.googlesource.com/foo", Version: "v1.0.0"}
+	result, err := generateOne(pkg, portfileTemplate, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, msg := range result.Warnings {
+		if strings.HasPrefix(msg, "Could not calculate checksums") {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("expected one checksum warning; got %q", result.Warnings)
+	}
+	if result.Checksums != placeholderChecksums {
+		t.Errorf("unexpected checksums: %+v", result.Checksums)
+	}
+}
+
+func TestVendorChecksums(t *testing.T) {
+	shared := "https://github.com/example/shared/tarball/v2.0.0"
+	requests := fakeHttp(t, map[string]string{shared: "tarball"})
+	vendors := resolveVendors([]Dependency{
+		{Name: "github.com/example/multi/a", Version: "v1.0.0"},
+		{Name: "github.com/example/multi/b", Version: "v1.0.0"},
+		// The same repo at the same revision
+		{Name: "gopkg.in/example/shared.v2", Version: "v2.0.0"},
+		{Name: "github.com/example/shared/v2", Version: "v2.0.0"},
+	}, scanChecksums)
+
+	// Modules in subdirectories are tagged with the subdirectory as a prefix
+	for _, vendor := range vendors[:2] {
+		url := "https://github.com/example/multi/tarball/" + vendor.Package.Version
+		if vendor.TarballUrl != url || requests.count(url) != 1 {
+			t.Errorf("%s: expected one request to %s, got %s (%d)", vendor.Name, url, vendor.TarballUrl, requests.count(url))
+		}
+		if len(vendor.Warnings) != 1 || !strings.HasPrefix(vendor.Warnings[0], "Could not calculate checksums for package: "+vendor.Name) {
+			t.Errorf("%s: unexpected warnings: %q", vendor.Name, vendor.Warnings)
+		}
+		if vendor.Checksums != placeholderChecksums {
+			t.Errorf("%s: unexpected checksums: %+v", vendor.Name, vendor.Checksums)
+		}
+	}
+
+	a, b := vendors[2], vendors[3]
+	if a.TarballUrl != shared || b.TarballUrl != shared {
+		t.Fatalf("expected both to use %s, got %s and %s", shared, a.TarballUrl, b.TarballUrl)
+	}
+	if count := requests.count(shared); count != 1 {
+		t.Errorf("expected %s to be fetched once, got %d", shared, count)
+	}
+	if len(a.Warnings) != 0 || a.Checksums == placeholderChecksums || a.Checksums != b.Checksums {
+		t.Errorf("expected shared checksums, got %+v and %+v (%q)", a.Checksums, b.Checksums, a.Warnings)
+	}
+}
+
+func TestVendorMajorLayout(t *testing.T) {
+	modUrl := "https://raw.githubusercontent.com/example/major/v3.1.0/v3/go.mod"
+	requests := fakeHttp(t, map[string]string{modUrl: "module github.com/example/major/v3\n"})
+	deps := []Dependency{{Name: "github.com/example/major/v3", Version: "v3.1.0"}}
+
+	// Checksums don't depend on the layout, so it isn't looked up
+	vendor := resolveVendors(deps, scanChecksums)[0]
+	if vendor.Package.MajorSubdir || requests.count(modUrl) != 0 {
+		t.Errorf("unexpected layout lookup for checksums only: %t (%d)", vendor.Package.MajorSubdir, requests.count(modUrl))
+	}
+	vendor = resolveVendors(deps, scanImports)[0]
+	if !vendor.Package.MajorSubdir || vendor.Package.srcDir() != "v3" {
+		t.Errorf("expected the major subdirectory layout, got %q", vendor.Package.srcDir())
+	}
+}
+
+// Write minimal portfiles for the given packages by port name, and put a
+// stand-in for `port file` that finds them on PATH. Returns the portfiles'
+// paths by port name.
+func fakePortfiles(t *testing.T, packages map[string]string) map[string]string {
+	dir := t.TempDir()
+	paths := make(map[string]string)
+	for portname, pkg := range packages {
+		path := filepath.Join(dir, portname, "Portfile")
+		existing := "PortSystem          1.0\nPortGroup           golang 1.0\n\ngo.setup            " + pkg + " 1.0.0 v\n"
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(existing), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths[portname] = path
+	}
+	port := "#!/bin/sh\necho " + dir + "/$2/Portfile\n"
+	if err := os.WriteFile(filepath.Join(dir, "port"), []byte(port), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return paths
+}
+
+// What f prints to stdout
+func captureStdout(t *testing.T, f func() error) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- f()
+		w.Close()
+	}()
+	out, _ := io.ReadAll(r)
+	os.Stdout = stdout
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestUpdateOrder(t *testing.T) {
+	fakeHttp(t, nil)
+	names := []string{"b", "a", "d", "c"}
+	packages := make(map[string]string)
+	for _, name := range names {
+		packages["tool-"+name] = "github.com/example/tool-" + name
+		pkg, err := newPackage(packages["tool-"+name], "v1.1.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		seedPackage(pkg, "module "+pkg.Id+"\n", &Source{})
+	}
+	fakePortfiles(t, packages)
+
+	set := flag.NewFlagSet("update", flag.ContinueOnError)
+	set.String("output", "-", "")
+	set.String("format", "portfile", "")
+	set.String("template", "", "")
+	args := []string{"--template", writeTemp(t, "{{.PackageId}}\n")}
+	var expected strings.Builder
+	for _, name := range names {
+		args = append(args, "tool-"+name, "1.1.0")
+		expected.WriteString("github.com/example/tool-" + name + "\n")
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	actual := captureStdout(t, func() error { return update(cli.NewContext(nil, set, nil)) })
+	if actual != expected.String() {
+		t.Errorf("portfiles not printed in argument order:\n%s", actual)
+	}
+}
+
+// The path of a temporary file containing content
+func writeTemp(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestTemplateFile(t *testing.T) {
+	fakeHttp(t, nil)
+	dir := t.TempDir()
+	templateFile := filepath.Join(dir, "Portfile.tmpl")
+	if err := os.WriteFile(templateFile, []byte("custom {{.PackageId}} {{.Version}}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	set := flag.NewFlagSet("update", flag.ContinueOnError)
+	templateFlag.Apply(set)
+	if err := set.Parse([]string{"--template", templateFile}); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := optionsFrom(cli.NewContext(nil, set, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Template != "custom {{.PackageId}} {{.Version}}\n" {
+		t.Fatalf("template file not read: %q", opts.Template)
+	}
+
+	portfiles := fakePortfiles(t, map[string]string{"templated": "github.com/example/templated"})
+	pkg, err := newPackage("github.com/example/templated", "v1.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedPackage(pkg, "module github.com/example/templated\n", &Source{})
+	if _, err := updateOne("templated", "1.1.0", "", opts, "portfile"); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := os.ReadFile(portfiles["templated"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != "custom github.com/example/templated 1.1.0\n" {
+		t.Errorf("--template did not override the existing portfile:\n%s", actual)
+	}
+}
+
+func TestTemplateRedefinesBlocks(t *testing.T) {
+	fakeHttp(t, nil)
+	pkg, err := newPackage("github.com/example/blocks", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedPackage(pkg, "module github.com/example/blocks\n", &Source{})
+	tmplate := `{{define "checksums"}}sha256 {{.Checksums.Sha256}}{{end}}{{define "go.vendors"}}vendors {{len .}}{{end}}{{.Checksums}}
+{{.GoVendors}}`
+	result, err := generateOne(pkg, tmplate, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Portfile != "sha256 s\nvendors 0" {
+		t.Errorf("redefined blocks not used for .Checksums and .GoVendors:\n%s", result.Portfile)
+	}
+}
+
+func TestOptIns(t *testing.T) {
+	requests := fakeHttp(t, map[string]string{
+		"https://api.github.com/repos/example/optins": `{"description": "SQL client", "homepage": "https://optins.dev", "topics": ["database"]}`,
+	})
+	pkg, err := newPackage("github.com/example/optins", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedPackage(pkg, "module github.com/example/optins\n", &Source{
+		MainPackages: []string{"."},
+		VersionVars:  map[string][]string{".": {"main.version"}},
+		Licenses:     map[string]LicenseMatch{".": {Spdx: "MIT", Keyword: "MIT", File: "LICENSE", Confidence: 1}},
+		Readmes:      map[string]string{".": "A command-line client for your SQL database."},
+	})
+	base := `# -*- coding: utf-8; mode: tcl; tab-width: 4; indent-tabs-mode: nil; c-basic-offset: 4 -*- vim:fenc=utf-8:ft=tcl:et:sw=4:ts=4:sts=4
+
+PortSystem          1.0
+PortGroup           golang 1.0
+
+go.setup            github.com/example/optins v1.0.0
+categories
+maintainers
+license
+
+description
+
+long_description
+
+checksums           rmd160  r \
+                    sha256  s \
+                    size    1
+
+
+
+destroot {
+    xinstall -m 0755 ${worksrcpath}/${name} ${destroot}${prefix}/bin/
+}
+`
+	tests := []struct {
+		name string
+		opts Options
+		// Replacements in the default portfile
+		changes []string
+	}{
+		{"default", Options{}, nil},
+		{"version ldflags", Options{VersionLdflags: true}, []string{
+			"\ndestroot {", "\nbuild.args          -ldflags \"'-X main.version=${version}'\"\n\ndestroot {",
+		}},
+		{"cgo", Options{Cgo: true}, []string{
+			"\ndestroot {", "\nbuild.env-append    CGO_ENABLED=0\n\ndestroot {",
+		}},
+		{"license", Options{License: true}, []string{
+			"\nlicense\n", "\nlicense             MIT\n",
+		}},
+		{"metadata", Options{Metadata: true}, []string{
+			"\ndescription\n\nlong_description\n", "\ndescription         SQL client\n\nlong_description    A command-line client for your SQL database.\n\nhomepage            https://optins.dev\n",
+		}},
+		{"categories", Options{Categories: true}, []string{
+			"\ncategories\n", "\ncategories          databases sysutils\n",
+		}},
+	}
+	for _, test := range tests {
+		result, err := generateOne(pkg, portfileTemplate, test.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		expected := base
+		for i := 0; i < len(test.changes); i += 2 {
+			expected = strings.Replace(expected, test.changes[i], test.changes[i+1], 1)
+		}
+		if result.Portfile != expected {
+			t.Errorf("%s: unexpected portfile:\n%s", test.name, result.Portfile)
+		}
+	}
+	if count := requests.count("https://api.github.com/repos/example/optins"); count != 1 {
+		t.Errorf("expected the forge to be queried only with --metadata, got %d requests", count)
+	}
+}
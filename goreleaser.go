@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The parts of a .goreleaser.yml we understand
+type goreleaserConfig struct {
+	Env    []string          `yaml:"env"`
+	Builds []goreleaserBuild `yaml:"builds"`
+}
+
+type goreleaserBuild struct {
+	Builder string       `yaml:"builder"`
+	Dir     string       `yaml:"dir"`
+	Main    string       `yaml:"main"`
+	Binary  string       `yaml:"binary"`
+	Flags   stringOrList `yaml:"flags"`
+	Ldflags stringOrList `yaml:"ldflags"`
+	Tags    stringOrList `yaml:"tags"`
+	Env     []string     `yaml:"env"`
+	Goos    []string     `yaml:"goos"`
+	Skip    interface{}  `yaml:"skip"`
+}
+
+// goreleaser accepts either a single string or a list for many fields
+type stringOrList []string
+
+func (s *stringOrList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*s = list
+		return nil
+	}
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+	*s = []string{str}
+	return nil
+}
+
+func readGoreleaser(data []byte) (goreleaserConfig, error) {
+	cfg := goreleaserConfig{}
+	err := yaml.Unmarshal(data, &cfg)
+	return cfg, err
+}
+
+var goreleaserFiles = []string{".goreleaser.yml", ".goreleaser.yaml"}
+
+// Look for a goreleaser config in the lockfile directory, then at the repo
+// root. Returns nil if there is none.
+func fetchGoreleaser(pkg Package, lockfileDir string) *goreleaserConfig {
+	dirs := []string{lockfileDir}
+	if lockfileDir != "/" {
+		dirs = append(dirs, "/")
+	}
+	for _, dir := range dirs {
+		for _, file := range goreleaserFiles {
+			url, err := rawFileUrl(pkg, dir, file)
+			if err != nil {
+				return nil
+			}
+			if debugOn {
+				log.Printf("Looking for goreleaser config at %s", url)
+			}
+			status, data, err := fetch(url)
+			if err != nil || status != 200 {
+				continue
+			}
+			cfg, err := readGoreleaser(data)
+			if err != nil {
+				if debugOn {
+					log.Printf("Could not read %s: %s", url, err)
+				}
+				return nil
+			}
+			return &cfg
+		}
+	}
+	return nil
+}
+
+// Whether a build is one we should reproduce: a Go build that targets macOS
+func (b *goreleaserBuild) applies() bool {
+	if skip, ok := b.Skip.(bool); ok && skip {
+		return false
+	}
+	if b.Builder != "" && b.Builder != "go" {
+		return false
+	}
+	if len(b.Goos) == 0 {
+		return true
+	}
+	for _, goos := range b.Goos {
+		if goos == "darwin" {
+			return true
+		}
+	}
+	return false
+}
+
+// The main package of a build, relative to the repo root
+func (b *goreleaserBuild) mainDir() string {
+	main := b.Main
+	// main may name a file rather than a package
+	if strings.HasSuffix(main, ".go") {
+		main = path.Dir(main)
+	}
+	return path.Clean(path.Join(b.Dir, main))
+}
+
+var goreleaserTemplateReg = regexp.MustCompile(`\{\{-?\s*([^}]*?)\s*-?\}\}`)
+
+// Replace goreleaser template expressions with their Portfile equivalents.
+// Returns false if the value uses a template we can't translate (e.g. the
+// commit hash or build date).
+func translateGoreleaserTemplate(pkg Package, value string) (string, bool) {
+	ok := true
+	ret := goreleaserTemplateReg.ReplaceAllStringFunc(value, func(expr string) string {
+		switch goreleaserTemplateReg.FindStringSubmatch(expr)[1] {
+		case ".Version", ".RawVersion":
+			return goreleaserVersion(pkg)
+		case ".Tag":
+			return pkg.TagPrefix + "${version}"
+		case ".ProjectName":
+			return "${name}"
+		}
+		ok = false
+		return expr
+	})
+	return ret, ok
+}
+
+// goreleaser's {{.Version}} is the tag without its prefix or leading v. For
+// root modules go.setup takes the whole tag, so ${version} keeps the v.
+func goreleaserVersion(pkg Package) string {
+	if strings.HasPrefix(pkg.setupVersion(), "v") {
+		return "[string range ${version} 1 end]"
+	}
+	return "${version}"
+}
+
+// Drop the -X flags, which set variables such as the version, from ldflags
+func withoutVariables(ldflags []string) []string {
+	var ret []string
+	for _, ldflag := range ldflags {
+		if !strings.HasPrefix(ldflag, "-X ") {
+			ret = append(ret, ldflag)
+		}
+	}
+	return ret
+}
+
+// Split ldflags into individual flags, keeping -X and its argument together
+// and dropping any that can't be expressed in a Portfile
+func goreleaserLdflags(pkg Package, ldflags []string) []string {
+	var fields []string
+	for _, ldflag := range ldflags {
+		for _, field := range strings.Fields(ldflag) {
+			fields = append(fields, strings.Trim(field, `'"`))
+		}
+	}
+	var ret []string
+	for i := 0; i < len(fields); i++ {
+		flag := fields[i]
+		if flag == "-X" && i+1 < len(fields) {
+			flag = "-X " + fields[i+1]
+			i++
+		}
+		if translated, ok := translateGoreleaserTemplate(pkg, flag); ok {
+			ret = append(ret, translated)
+		}
+	}
+	return ret
+}
+
+// Translate the macOS builds of a goreleaser config into a Build. Builds
+// chosen with --cmd are matched by binary name or main package. Only
+// flags shared by the first build are used, as a single go build invocation
+// can't apply different flags per binary.
+func goreleaserToBuild(pkg Package, cfg goreleaserConfig, cmds []string) (Build, []string, error) {
+	var builds []goreleaserBuild
+	for _, b := range cfg.Builds {
+		if b.applies() {
+			builds = append(builds, b)
+		}
+	}
+	if len(cmds) > 0 {
+		var selected []goreleaserBuild
+		for _, cmd := range cmds {
+			found := false
+			for _, b := range builds {
+				if b.Binary == cmd || b.mainDir() == path.Clean(cmd) {
+					selected = append(selected, b)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return Build{}, nil, fmt.Errorf("Command %s not found in goreleaser config", cmd)
+			}
+		}
+		builds = selected
+	}
+	if len(builds) == 0 {
+		return Build{}, nil, errors.New("No macOS Go builds in goreleaser config")
+	}
+
+	var warnings []string
+	first := builds[0]
+	build := Build{}
+	for _, env := range append(cfg.Env, first.Env...) {
+		if translated, ok := translateGoreleaserTemplate(pkg, env); ok {
+			build.Env = append(build.Env, translated)
+		}
+	}
+	for _, flag := range first.Flags {
+		if translated, ok := translateGoreleaserTemplate(pkg, flag); ok {
+			build.Flags = append(build.Flags, translated)
+		}
+	}
+	if len(first.Tags) > 0 {
+		build.Flags = append(build.Flags, "-tags", strings.Join(first.Tags, ","))
+	}
+	build.Ldflags = goreleaserLdflags(pkg, first.Ldflags)
+	for _, b := range builds[1:] {
+		if strings.Join(b.Ldflags, " ") != strings.Join(first.Ldflags, " ") ||
+			strings.Join(b.Flags, " ") != strings.Join(first.Flags, " ") ||
+			strings.Join(b.Tags, ",") != strings.Join(first.Tags, ",") ||
+			strings.Join(b.Env, " ") != strings.Join(first.Env, " ") {
+			warnings = append(warnings, fmt.Sprintf("goreleaser builds use different flags; using those of %s", first.mainDir()))
+			break
+		}
+	}
+
+	build.Dir = path.Clean(first.Dir)
+	if build.Dir == "." {
+		build.Dir = ""
+	}
+	for _, b := range builds {
+		rel, ok := relativeDir(build.Dir, b.mainDir())
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("Skipping goreleaser build of %s outside %s", b.mainDir(), build.Dir))
+			continue
+		}
+		output := binaryName(pkg, rel)
+		binary := output
+		if translated, ok := translateGoreleaserTemplate(pkg, b.Binary); ok && b.Binary != "" {
+			binary = path.Base(translated)
+		}
+		build.Packages = append(build.Packages, rel)
+		build.Binaries = append(build.Binaries, binary)
+		build.Outputs = append(build.Outputs, output)
+	}
+	return build, warnings, nil
+}
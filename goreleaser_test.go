@@ -0,0 +1,143 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const goreleaserYml = `
+version: 2
+env:
+  - CGO_ENABLED=0
+builds:
+  - id: foo
+    main: ./cmd/foo
+    binary: foo-cli
+    flags:
+      - -trimpath
+    tags:
+      - netgo
+    ldflags: -s -w -X main.version={{.Version}} -X main.commit={{.Commit}} -X main.builtBy=goreleaser
+    goos: [linux, darwin, windows]
+  - id: bar
+    main: ./cmd/bar/main.go
+    flags:
+      - -trimpath
+    tags:
+      - netgo
+    ldflags: -s -w -X main.version={{.Version}} -X main.commit={{.Commit}} -X main.builtBy=goreleaser
+  - id: win
+    main: ./cmd/win
+    goos: [windows]
+`
+
+func TestGoreleaserToBuild(t *testing.T) {
+	cfg, err := readGoreleaser([]byte(goreleaserYml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := newPackage("github.com/foo/bar", "v1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	build, warnings, err := goreleaserToBuild(pkg, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+	expected := `build.env-append    CGO_ENABLED=0
+build.args          -trimpath -tags netgo -ldflags "'-s -w -X main.version=[string range ${version} 1 end] -X main.builtBy=goreleaser'" -o ${worksrcpath}/bin/ ./cmd/foo ./cmd/bar`
+	if build.String() != expected {
+		t.Errorf("unexpected build:\n%s", build.String())
+	}
+	expected = `destroot {
+    xinstall -m 0755 ${worksrcpath}/bin/foo ${destroot}${prefix}/bin/foo-cli
+    xinstall -m 0755 ${worksrcpath}/bin/bar ${destroot}${prefix}/bin/
+}`
+	if build.destrootStr() != expected {
+		t.Errorf("unexpected destroot:\n%s", build.destrootStr())
+	}
+
+	build, _, err = goreleaserToBuild(pkg, cfg, []string{"foo-cli"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(build.Packages, []string{"./cmd/foo"}) {
+		t.Errorf("unexpected packages: %v", build.Packages)
+	}
+	if _, _, err = goreleaserToBuild(pkg, cfg, []string{"win"}); err == nil {
+		t.Errorf("expected error for Windows-only build")
+	}
+}
+
+func TestTranslateGoreleaserTemplate(t *testing.T) {
+	// go.setup takes the whole tag of a root module, so ${version} is v1.2.3
+	pkg, err := newPackage("github.com/foo/bar", "v1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"-X main.version={{ .Version }}": "-X main.version=[string range ${version} 1 end]",
+		"-X main.tag={{.Tag}}":           "-X main.tag=${version}",
+		"{{ .ProjectName }}":             "${name}",
+	}
+	for input, expected := range tests {
+		actual, ok := translateGoreleaserTemplate(pkg, input)
+		if !ok || actual != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", input, expected, actual, ok)
+		}
+	}
+	// Subdirectory modules set go.setup to the version without the tag prefix
+	subdir := Package{Version: "cmd/tool/v1.2.3", TagPrefix: "cmd/tool/v"}
+	if actual, _ := translateGoreleaserTemplate(subdir, "{{.Version}}"); actual != "${version}" {
+		t.Errorf("unexpected version for subdirectory module: %s", actual)
+	}
+	if actual, _ := translateGoreleaserTemplate(subdir, "{{.Tag}}"); actual != "cmd/tool/v${version}" {
+		t.Errorf("unexpected tag for subdirectory module: %s", actual)
+	}
+	unprefixed := Package{Version: "1.2.3"}
+	if actual, _ := translateGoreleaserTemplate(unprefixed, "{{.Version}}"); actual != "${version}" {
+		t.Errorf("unexpected version without prefix: %s", actual)
+	}
+	if actual, _ := translateGoreleaserTemplate(unprefixed, "{{.Tag}}"); actual != "${version}" {
+		t.Errorf("unexpected tag without prefix: %s", actual)
+	}
+	if _, ok := translateGoreleaserTemplate(pkg, "-X main.date={{.Date}}"); ok {
+		t.Errorf("expected untranslatable template")
+	}
+}
+
+func TestGoreleaserVersionLdflags(t *testing.T) {
+	pkg, err := newPackage("github.com/example/released", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedPackage(pkg, "module github.com/example/released\n", &Source{
+		MainPackages: []string{"cmd/other"},
+		VersionVars:  map[string][]string{"cmd/other": {"main.version"}},
+	})
+	url, err := rawFileUrl(pkg, pkg.lockfileDir(""), ".goreleaser.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeHttp(t, map[string]string{url: goreleaserYml})
+
+	// The goreleaser build replaces the detected one either way
+	tests := map[bool]string{
+		false: `-ldflags "'-s -w'"`,
+		true:  `-ldflags "'-s -w -X main.version=[string range ${version} 1 end] -X main.builtBy=goreleaser'"`,
+	}
+	for versionLdflags, ldflags := range tests {
+		result, err := generateOne(pkg, portfileTemplate, Options{VersionLdflags: versionLdflags})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "build.args          -trimpath -tags netgo " + ldflags + " -o ${worksrcpath}/bin/ ./cmd/foo ./cmd/bar\n"
+		if !strings.Contains(result.Portfile, expected) {
+			t.Errorf("version ldflags %v: expected %q in portfile:\n%s", versionLdflags, expected, result.Portfile)
+		}
+	}
+}
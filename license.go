@@ -0,0 +1,400 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A license file classified against the known licenses
+type LicenseMatch struct {
+	// SPDX identifier
+	Spdx string `json:"spdx"`
+	// MacPorts license keyword
+	Keyword string `json:"keyword"`
+	// Path of the license file relative to the repo root
+	File string `json:"file"`
+	// Similarity to the license's reference text, from 0 to 1
+	Confidence float64 `json:"confidence"`
+}
+
+// Matches below this confidence are not reported
+const minLicenseConfidence = 0.5
+
+// Matches below this confidence are flagged for review in the portfile
+const certainLicenseConfidence = 0.9
+
+var licenseFileReg = regexp.MustCompile(`^(?i)(licen[cs]e|copying|unlicense)([.\-_].*)?$`)
+
+func isLicenseFile(name string) bool {
+	return licenseFileReg.MatchString(path.Base(name))
+}
+
+// MacPorts license keywords by SPDX identifier
+var licenseKeywords = map[string]string{
+	"MIT":          "MIT",
+	"BSD-2-Clause": "BSD",
+	"BSD-3-Clause": "BSD",
+	"ISC":          "ISC",
+	"Zlib":         "zlib",
+	"Unlicense":    "public-domain",
+	"CC0-1.0":      "CC0-1",
+	"Apache-2.0":   "Apache-2",
+	"MPL-2.0":      "MPL-2",
+	"GPL-2.0":      "GPL-2",
+	"LGPL-2.1":     "LGPL-2.1",
+	"GPL-3.0":      "GPL-3",
+	"LGPL-3.0":     "LGPL-3",
+	"AGPL-3.0":     "AGPL-3",
+}
+
+// Distinctive text of each license by SPDX identifier: the whole license for
+// short ones, and the opening for long ones. Copyright lines are omitted as
+// they are removed from the files being classified.
+var licenseTexts = map[string]string{
+	"MIT": `Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.`,
+	"BSD-2-Clause": `Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.`,
+	"BSD-3-Clause": `Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its
+contributors may be used to endorse or promote products derived from
+this software without specific prior written permission.
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.`,
+	"ISC": `Permission to use, copy, modify, and/or distribute this software for any
+purpose with or without fee is hereby granted, provided that the above
+copyright notice and this permission notice appear in all copies.
+THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.`,
+	"Zlib": `This software is provided 'as-is', without any express or implied
+warranty. In no event will the authors be held liable for any damages
+arising from the use of this software.
+Permission is granted to anyone to use this software for any purpose,
+including commercial applications, and to alter it and redistribute it
+freely, subject to the following restrictions:
+1. The origin of this software must not be misrepresented; you must not
+claim that you wrote the original software. If you use this software
+in a product, an acknowledgment in the product documentation would be
+appreciated but is not required.
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+3. This notice may not be removed or altered from any source distribution.`,
+	"Unlicense": `This is free and unencumbered software released into the public domain.
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.`,
+	"CC0-1.0": `Creative Commons Legal Code
+CC0 1.0 Universal
+CREATIVE COMMONS CORPORATION IS NOT A LAW FIRM AND DOES NOT PROVIDE
+LEGAL SERVICES. DISTRIBUTION OF THIS DOCUMENT DOES NOT CREATE AN
+ATTORNEY-CLIENT RELATIONSHIP. CREATIVE COMMONS PROVIDES THIS
+INFORMATION ON AN "AS-IS" BASIS. CREATIVE COMMONS MAKES NO WARRANTIES
+REGARDING THE USE OF THIS DOCUMENT OR THE INFORMATION OR WORKS
+PROVIDED HEREUNDER, AND DISCLAIMS LIABILITY FOR DAMAGES RESULTING FROM
+THE USE OF THIS DOCUMENT OR THE INFORMATION OR WORKS PROVIDED
+HEREUNDER.`,
+	"Apache-2.0": `Apache License
+Version 2.0, January 2004
+http://www.apache.org/licenses/
+TERMS AND CONDITIONS FOR USE, REPRODUCTION, AND DISTRIBUTION
+1. Definitions.
+"License" shall mean the terms and conditions for use, reproduction,
+and distribution as defined by Sections 1 through 9 of this document.
+"Licensor" shall mean the copyright owner or entity authorized by
+the copyright owner that is granting the License.
+"Legal Entity" shall mean the union of the acting entity and all
+other entities that control, are controlled by, or are under common
+control with that entity.`,
+	"MPL-2.0": `Mozilla Public License Version 2.0
+1. Definitions
+1.1. "Contributor"
+means each individual or legal entity that creates, contributes to
+the creation of, or owns Covered Software.
+1.2. "Contributor Version"
+means the combination of the Contributions of others (if any) used
+by a Contributor and that particular Contributor's Contribution.
+1.3. "Contribution"
+means Covered Software of a particular Contributor.`,
+	"GPL-2.0": `GNU GENERAL PUBLIC LICENSE
+Version 2, June 1991
+Everyone is permitted to copy and distribute verbatim copies
+of this license document, but changing it is not allowed.
+Preamble
+The licenses for most software are designed to take away your
+freedom to share and change it. By contrast, the GNU General Public
+License is intended to guarantee your freedom to share and change free
+software--to make sure the software is free for all its users. This
+General Public License applies to most of the Free Software
+Foundation's software and to any other program whose authors commit to
+using it.`,
+	"LGPL-2.1": `GNU LESSER GENERAL PUBLIC LICENSE
+Version 2.1, February 1999
+Everyone is permitted to copy and distribute verbatim copies
+of this license document, but changing it is not allowed.
+[This is the first released version of the Lesser GPL. It also counts
+as the successor of the GNU Library Public License, version 2, hence
+the version number 2.1.]
+Preamble
+The licenses for most software are designed to take away your
+freedom to share and change it. By contrast, the GNU General Public
+Licenses are intended to guarantee your freedom to share and change
+free software--to make sure the software is free for all its users.
+This license, the Lesser General Public License, applies to some
+specially designated software packages--typically libraries--of the
+Free Software Foundation and other authors who decide to use it.`,
+	"GPL-3.0": `GNU GENERAL PUBLIC LICENSE
+Version 3, 29 June 2007
+Everyone is permitted to copy and distribute verbatim copies
+of this license document, but changing it is not allowed.
+Preamble
+The GNU General Public License is a free, copyleft license for
+software and other kinds of works.
+The licenses for most software and other practical works are designed
+to take away your freedom to share and change the works. By contrast,
+the GNU General Public License is intended to guarantee your freedom to
+share and change all versions of a program--to make sure it remains free
+software for all its users. We, the Free Software Foundation, use the
+GNU General Public License for most of our software; it applies also to
+any other work released this way by its authors. You can apply it to
+your programs, too.`,
+	"LGPL-3.0": `GNU LESSER GENERAL PUBLIC LICENSE
+Version 3, 29 June 2007
+Everyone is permitted to copy and distribute verbatim copies
+of this license document, but changing it is not allowed.
+This version of the GNU Lesser General Public License incorporates
+the terms and conditions of version 3 of the GNU General Public
+License, supplemented by the additional permissions listed below.
+0. Additional Definitions.
+As used herein, "this License" refers to version 3 of the GNU Lesser
+General Public License, and the "GNU GPL" refers to version 3 of the GNU
+General Public License.`,
+	"AGPL-3.0": `GNU AFFERO GENERAL PUBLIC LICENSE
+Version 3, 19 November 2007
+Everyone is permitted to copy and distribute verbatim copies
+of this license document, but changing it is not allowed.
+Preamble
+The GNU Affero General Public License is a free, copyleft license for
+software and other kinds of works, specifically designed to ensure
+cooperation with the community in the case of network server software.
+The licenses for most software and other practical works are designed
+to take away your freedom to share and change the works. By contrast,
+our General Public Licenses are intended to guarantee your freedom to
+share and change all versions of a program--to make sure it remains free
+software for all its users.`,
+}
+
+// Word trigrams of each reference text
+var licenseShingles = func() map[string]map[string]bool {
+	ret := make(map[string]map[string]bool)
+	for spdx, text := range licenseTexts {
+		ret[spdx] = shingles(text)
+	}
+	return ret
+}()
+
+var nonWordReg = regexp.MustCompile(`[^a-z0-9]+`)
+
+// The set of word trigrams of text, ignoring case, punctuation, and copyright
+// lines
+func shingles(text string) map[string]bool {
+	var words []string
+	for _, line := range strings.Split(strings.ToLower(text), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "copyright") || strings.HasPrefix(trimmed, "(c)") {
+			continue
+		}
+		words = append(words, strings.Fields(nonWordReg.ReplaceAllString(line, " "))...)
+	}
+	ret := make(map[string]bool)
+	for i := 0; i+3 <= len(words); i++ {
+		ret[strings.Join(words[i:i+3], " ")] = true
+	}
+	return ret
+}
+
+// Classify a license file by the fraction of each reference text it contains.
+// Where several references are contained about equally well, the longest
+// (most specific) wins, e.g. 3-clause over 2-clause BSD. Returns nil if
+// nothing matches well enough.
+func classifyLicense(name string, text []byte) *LicenseMatch {
+	candidate := shingles(string(text))
+	ids := make([]string, 0, len(licenseShingles))
+	for spdx := range licenseShingles {
+		ids = append(ids, spdx)
+	}
+	sort.Strings(ids)
+	var best *LicenseMatch
+	bestSize := 0
+	for _, spdx := range ids {
+		ref := licenseShingles[spdx]
+		found := 0
+		for shingle := range ref {
+			if candidate[shingle] {
+				found++
+			}
+		}
+		score := float64(found) / float64(len(ref))
+		if score < minLicenseConfidence {
+			continue
+		}
+		if best == nil || score > best.Confidence+0.02 ||
+			(score > best.Confidence-0.02 && len(ref) > bestSize) {
+			best = &LicenseMatch{Spdx: spdx, Keyword: licenseKeywords[spdx], File: name, Confidence: score}
+			bestSize = len(ref)
+		}
+	}
+	return best
+}
+
+// Pick the license of a directory from the matches of its license files. A
+// COPYING.LESSER (as shipped by LGPL projects next to the GPL) wins; otherwise
+// the most confident match.
+func betterLicense(a *LicenseMatch, b *LicenseMatch) *LicenseMatch {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	aLesser := strings.Contains(strings.ToUpper(path.Base(a.File)), "LESSER")
+	bLesser := strings.Contains(strings.ToUpper(path.Base(b.File)), "LESSER")
+	if aLesser != bLesser {
+		if aLesser {
+			return a
+		}
+		return b
+	}
+	if b.Confidence > a.Confidence {
+		return b
+	}
+	return a
+}
+
+// The license applying to dir: that of the nearest directory at or above it
+// with a license file
+func (src *Source) license(dir string) *LicenseMatch {
+	if found, ok := nearestDir(dir, func(d string) bool { _, ok := src.Licenses[d]; return ok }); ok {
+		match := src.Licenses[found]
+		return &match
+	}
+	return nil
+}
+
+func licenseStr(match *LicenseMatch) string {
+	if match == nil {
+		return ""
+	}
+	return match.Keyword
+}
+
+// A comment asking for the license to be checked, if we aren't sure of it
+func licenseNote(match *LicenseMatch) string {
+	if match == nil || match.Confidence >= certainLicenseConfidence {
+		return ""
+	}
+	return fmt.Sprintf("# license guessed from %s with %.0f%% confidence; please verify", match.File, match.Confidence*100)
+}
+
+// Licenses that may restrict distribution of binaries that statically link
+// the licensed code, as Go binaries do
+var restrictiveLicenses = map[string]bool{
+	"GPL-2.0":  true,
+	"GPL-3.0":  true,
+	"LGPL-2.1": true,
+	"LGPL-3.0": true,
+	"AGPL-3.0": true,
+}
+
+// Warnings about vendored modules whose license is copyleft or unknown
+func vendorLicenseWarnings(vendors []Vendor) []string {
+	var ret []string
+	for _, vendor := range vendors {
+		if vendor.License == nil {
+			ret = append(ret, fmt.Sprintf("Could not determine license of vendored module %s", vendor.Name))
+		} else if restrictiveLicenses[vendor.License.Spdx] {
+			ret = append(ret, fmt.Sprintf("Vendored module %s is licensed %s; check license_noconflict and whether binaries can be distributed", vendor.Name, vendor.License.Keyword))
+		}
+	}
+	return ret
+}
+
+// A comment listing the license of each vendored module, marking those that
+// need review
+func vendorLicensesComment(vendors []Vendor) string {
+	if len(vendors) == 0 {
+		return ""
+	}
+	lines := []string{"# Licenses of vendored modules:"}
+	for _, vendor := range vendors {
+		keyword := "unknown"
+		review := true
+		if vendor.License != nil {
+			keyword = vendor.License.Keyword
+			review = restrictiveLicenses[vendor.License.Spdx]
+		}
+		line := fmt.Sprintf("#   %-12s%s", keyword, vendor.Name)
+		if review {
+			line += " (review)"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
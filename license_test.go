@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+const mitLicense = `MIT License
+
+Copyright (c) 2020 Foo Bar
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+`
+
+func TestClassifyLicense(t *testing.T) {
+	match := classifyLicense("LICENSE", []byte(mitLicense))
+	if match == nil || match.Keyword != "MIT" || match.Confidence < certainLicenseConfidence {
+		t.Errorf("expected MIT, got %+v", match)
+	}
+
+	// Our own license is 3-clause BSD
+	ours, err := os.ReadFile("LICENSE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	match = classifyLicense("LICENSE", ours)
+	if match == nil || match.Spdx != "BSD-3-Clause" || match.Keyword != "BSD" {
+		t.Errorf("expected BSD-3-Clause, got %+v", match)
+	}
+
+	for _, spdx := range []string{"BSD-2-Clause", "Apache-2.0", "GPL-2.0", "LGPL-2.1", "GPL-3.0", "AGPL-3.0"} {
+		match = classifyLicense("COPYING", []byte(licenseTexts[spdx]+"\n\nMore terms follow."))
+		if match == nil || match.Spdx != spdx {
+			t.Errorf("expected %s, got %+v", spdx, match)
+		}
+	}
+
+	if match = classifyLicense("LICENSE", []byte("All rights reserved. Do not copy.")); match != nil {
+		t.Errorf("expected no match, got %+v", match)
+	}
+}
+
+func TestSourceLicense(t *testing.T) {
+	tarball := makeTarball(t, map[string]string{
+		"COPYING":             licenseTexts["GPL-3.0"],
+		"COPYING.LESSER":      licenseTexts["LGPL-3.0"],
+		"contrib/LICENSE.txt": mitLicense,
+		"contrib/foo/foo.go":  "package foo\n",
+	})
+	src, err := scanSource(tarball, scanMain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match := src.license(""); match == nil || match.Keyword != "LGPL-3" {
+		t.Errorf("expected LGPL-3 at root, got %+v", match)
+	}
+	if match := src.license("contrib/foo"); match == nil || match.Keyword != "MIT" || match.File != "contrib/LICENSE.txt" {
+		t.Errorf("expected MIT in contrib, got %+v", match)
+	}
+	if licenseNote(&LicenseMatch{File: "LICENSE", Confidence: 0.6}) != "# license guessed from LICENSE with 60% confidence; please verify" {
+		t.Errorf("unexpected note")
+	}
+}
+
+func TestVendorLicenses(t *testing.T) {
+	vendors := []Vendor{
+		{Dependency: Dependency{Name: "github.com/foo/mit"}, License: &LicenseMatch{Spdx: "MIT", Keyword: "MIT"}},
+		{Dependency: Dependency{Name: "github.com/foo/gpl"}, License: &LicenseMatch{Spdx: "GPL-3.0", Keyword: "GPL-3"}},
+		{Dependency: Dependency{Name: "github.com/foo/unknown"}},
+	}
+	expected := `# Licenses of vendored modules:
+#   MIT         github.com/foo/mit
+#   GPL-3       github.com/foo/gpl (review)
+#   unknown     github.com/foo/unknown (review)`
+	if actual := vendorLicensesComment(vendors); actual != expected {
+		t.Errorf("unexpected comment:\n%s", actual)
+	}
+	if warnings := vendorLicenseWarnings(vendors); len(warnings) != 2 {
+		t.Errorf("expected 2 warnings, got %v", warnings)
+	}
+}
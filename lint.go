@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// An entry of a go.vendors block as written in a portfile
+type DeclaredVendor struct {
+	Id        string
+	Repo      string
+	Lock      string
+	Checksums Checksums
+}
+
+var vendorKeywords = map[string]bool{
+	"repo": true, "lock": true, "rmd160": true, "sha256": true, "size": true,
+}
+
+// Read the entries of every go.vendors block in a portfile, in order
+func parseGoVendors(portfile string) []DeclaredVendor {
+	var ret []DeclaredVendor
+	for _, entry := range parseKeywordBlocks(portfile, goVendorsPattern, vendorKeywords) {
+		ret = append(ret, DeclaredVendor{
+			Id:        entry.Name,
+			Repo:      entry.Values["repo"],
+			Lock:      entry.Values["lock"],
+			Checksums: entry.checksums(),
+		})
+	}
+	return ret
+}
+
+// Differences between a portfile's go.vendors and what upstream requires
+func lintVendors(declared []DeclaredVendor, expected []Vendor) []string {
+	var problems []string
+	byId := make(map[string]DeclaredVendor)
+	for _, d := range declared {
+		if _, ok := byId[d.Id]; ok {
+			problems = append(problems, fmt.Sprintf("duplicate: %s", d.Id))
+		}
+		byId[d.Id] = d
+	}
+	seen := make(map[string]bool)
+	for _, vendor := range expected {
+		pkg := vendor.Package
+		seen[pkg.Id] = true
+		d, ok := byId[pkg.Id]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("missing: %s (lock %s)", pkg.Id, pkg.Version))
+			continue
+		}
+		repo := d.Repo
+		if repo == "" {
+			repo = d.Id
+		}
+		if repo != pkg.ResolvedId {
+			problems = append(problems, fmt.Sprintf("wrong repo: %s is %s, expected %s", pkg.Id, repo, pkg.ResolvedId))
+		}
+		if d.Lock != pkg.Version {
+			problems = append(problems, fmt.Sprintf("wrong lock: %s is %s, expected %s", pkg.Id, d.Lock, pkg.Version))
+			// Checksums are bound to differ
+			continue
+		}
+		// Without a download there are no actual checksums to compare
+		switch {
+		case vendor.TarballUrl == "":
+			problems = append(problems, fmt.Sprintf("unresolved: %s", pkg.Id))
+		case len(vendor.Warnings) > 0:
+			problems = append(problems, fmt.Sprintf("download failed: %s: %s", pkg.Id, strings.Join(vendor.Warnings, "; ")))
+		default:
+			for _, field := range checksumMismatches(d.Checksums, vendor.Checksums) {
+				problems = append(problems, fmt.Sprintf("checksum mismatch: %s %s", pkg.Id, field))
+			}
+		}
+	}
+	for _, d := range declared {
+		if !seen[d.Id] {
+			problems = append(problems, fmt.Sprintf("extra: %s", d.Id))
+		}
+	}
+	return problems
+}
+
+// Descriptions of the declared checksums that differ from the actual ones.
+// Checksum types missing from the declaration are ignored.
+func checksumMismatches(declared Checksums, actual Checksums) []string {
+	var ret []string
+	check := func(name string, want string, got string) {
+		if want != "" && want != got {
+			ret = append(ret, fmt.Sprintf("%s is %s, actual %s", name, want, got))
+		}
+	}
+	check("rmd160", declared.Rmd160, actual.Rmd160)
+	check("sha256", declared.Sha256, actual.Sha256)
+	check("size", declared.Size, actual.Size)
+	return ret
+}
+
+func lintOne(portname string, lockfileDir string) ([]string, error) {
+	portfilePath, portfile, err := readPortfile(portname)
+	if err != nil {
+		return nil, err
+	}
+	version, err := versionFromPortfile(portfile)
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := portfilePackage(portfilePath, portfile, version)
+	if err != nil {
+		return nil, err
+	}
+	detectMajorLayout(&pkg)
+	deps, err := dependencies(pkg, pkg.lockfileDir(lockfileDir))
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve dependencies of %s at %s: %w", pkg.Id, pkg.Version, err)
+	}
+	expected := resolveVendors(deps, scanChecksums)
+	return lintVendors(parseGoVendors(portfile), expected), nil
+}
+
+func lint(c *cli.Context) error {
+	lockfileDir := c.String("dir")
+	return forEachPort(c, func(portname string) ([]string, error) {
+		return lintOne(portname, lockfileDir)
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLintVendors(t *testing.T) {
+	vendor := func(id string, resolved string, version string, sha string) Vendor {
+		return Vendor{
+			Dependency: Dependency{Name: id, Version: version},
+			Package:    Package{Id: id, ResolvedId: resolved, Version: version},
+			TarballUrl: "https://" + resolved + "/tarball",
+			Checksums:  Checksums{Rmd160: "r", Sha256: sha, Size: "1"},
+		}
+	}
+	portfile := "go.setup            github.com/foo/bar 1.0.0\n\n" + vendorsBlock([]Vendor{
+		vendor("example.com/a", "github.com/x/a", "v1.0.0", "aaa"),
+		vendor("github.com/x/b", "github.com/x/b", "v1.0.0", "bbb"),
+		vendor("github.com/x/c", "github.com/x/c", "v1.0.0", "ccc"),
+		vendor("github.com/x/extra", "github.com/x/extra", "v1.0.0", "eee"),
+	}, false) + "\n"
+
+	declared := parseGoVendors(portfile)
+	if len(declared) != 4 {
+		t.Fatalf("unexpected declared vendors: %+v", declared)
+	}
+	expectedFirst := DeclaredVendor{Id: "example.com/a", Repo: "github.com/x/a", Lock: "v1.0.0", Checksums: Checksums{"r", "aaa", "1"}}
+	if !reflect.DeepEqual(declared[0], expectedFirst) {
+		t.Errorf("unexpected first vendor: %+v", declared[0])
+	}
+
+	upstream := []Vendor{
+		vendor("example.com/a", "github.com/x/a", "v1.0.0", "aaa"),
+		vendor("github.com/x/b", "github.com/x/b", "v1.1.0", "bbb2"),
+		vendor("github.com/x/c", "github.com/x/c", "v1.0.0", "changed"),
+		vendor("github.com/x/new", "github.com/x/new", "v0.1.0", "nnn"),
+	}
+	expected := []string{
+		"wrong lock: github.com/x/b is v1.0.0, expected v1.1.0",
+		"checksum mismatch: github.com/x/c sha256 is ccc, actual changed",
+		"missing: github.com/x/new (lock v0.1.0)",
+		"extra: github.com/x/extra",
+	}
+	if actual := lintVendors(declared, upstream); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("unexpected problems:\n%v", actual)
+	}
+	if actual := lintVendors(declared, upstream[:1]); len(actual) != 3 {
+		t.Errorf("expected only extras, got %v", actual)
+	}
+	if actual := lintVendors(declared[:1], upstream[:1]); len(actual) != 0 {
+		t.Errorf("expected no problems, got %v", actual)
+	}
+
+	// Vendors that couldn't be downloaded have no actual checksums
+	unresolved := vendor("example.com/a", "github.com/x/a", "v1.0.0", "0")
+	unresolved.TarballUrl = ""
+	failed := vendor("github.com/x/c", "github.com/x/c", "v1.0.0", "0")
+	failed.Warnings = []string{"Could not calculate checksums for package: github.com/x/c"}
+	expected = []string{
+		"unresolved: example.com/a",
+		"download failed: github.com/x/c: Could not calculate checksums for package: github.com/x/c",
+	}
+	if actual := lintVendors([]DeclaredVendor{declared[0], declared[2]}, []Vendor{unresolved, failed}); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("unexpected problems for failed downloads:\n%v", actual)
+	}
+}
+
+func TestVersionFromPortfile(t *testing.T) {
+	version, err := versionFromPortfile("go.setup            github.com/foo/bar 1.2.3 v\n")
+	if err != nil || version != "1.2.3" {
+		t.Errorf("unexpected version %s (%v)", version, err)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Descriptive information about a project from its forge and README
+type Metadata struct {
+	Description string `json:"description,omitempty"`
+	// The project's website, if it has one other than the repo
+	Homepage string   `json:"homepage,omitempty"`
+	Topics   []string `json:"topics,omitempty"`
+	// First paragraph of the README
+	Summary string `json:"summary,omitempty"`
+}
+
+// Authentication for API requests to host, if a token is configured.
+// Anonymous GitHub API requests are limited to 60 an hour.
+func forgeAuthHeader(host string) http.Header {
+	header := http.Header{}
+	switch host {
+	case "github.com":
+		if token := tokenFor("GITHUB_TOKEN", userConfig.Tokens.GitHub); token != "" {
+			header.Set("Authorization", "Bearer "+token)
+		}
+	case "gitlab.com":
+		if token := tokenFor("GITLAB_TOKEN", userConfig.Tokens.GitLab); token != "" {
+			header.Set("PRIVATE-TOKEN", token)
+		}
+	}
+	return header
+}
+
+func tokenFor(envVar string, configured string) string {
+	if token := os.Getenv(envVar); token != "" {
+		return token
+	}
+	return configured
+}
+
+// Query the forge hosting pkg for its description, website and topics
+func forgeMetadata(pkg Package) (Metadata, error) {
+	header := forgeAuthHeader(pkg.Host)
+	switch pkg.Host {
+	case "github.com":
+		var repo struct {
+			Description string   `json:"description"`
+			Homepage    string   `json:"homepage"`
+			Topics      []string `json:"topics"`
+		}
+		apiUrl := fmt.Sprintf("https://api.github.com/repos/%s/%s", pkg.Author, pkg.Project)
+		if err := fetchJson(apiUrl, header, &repo); err != nil {
+			return Metadata{}, err
+		}
+		return Metadata{Description: repo.Description, Homepage: repo.Homepage, Topics: repo.Topics}, nil
+	case "gitlab.com":
+		var repo struct {
+			Description string   `json:"description"`
+			Topics      []string `json:"topics"`
+		}
+		apiUrl := fmt.Sprintf("https://gitlab.com/api/v4/projects/%s", url.PathEscape(pkg.Author+"/"+pkg.Project))
+		if err := fetchJson(apiUrl, header, &repo); err != nil {
+			return Metadata{}, err
+		}
+		return Metadata{Description: repo.Description, Topics: repo.Topics}, nil
+	case "bitbucket.org":
+		var repo struct {
+			Description string `json:"description"`
+			Website     string `json:"website"`
+		}
+		apiUrl := fmt.Sprintf("https://api.bitbucket.org/2.0/repositories/%s/%s", pkg.Author, pkg.Project)
+		if err := fetchJson(apiUrl, header, &repo); err != nil {
+			return Metadata{}, err
+		}
+		return Metadata{Description: repo.Description, Homepage: repo.Website}, nil
+	case "git.sr.ht":
+		// The sr.ht API requires authentication, but the repo page carries the
+		// description
+		pageUrl := fmt.Sprintf("https://git.sr.ht/%s/%s", pkg.Author, pkg.Project)
+		status, body, err := fetch(pageUrl)
+		if err != nil {
+			return Metadata{}, err
+		}
+		if status != 200 {
+			return Metadata{}, fmt.Errorf("Could not fetch %s; HTTP status=%d", pageUrl, status)
+		}
+		doc, err := html.Parse(bytes.NewReader(body))
+		if err != nil {
+			return Metadata{}, err
+		}
+		return Metadata{Description: metaDescription(doc)}, nil
+	default:
+		if isGitea(pkg.Host) {
+			var repo struct {
+				Description string   `json:"description"`
+				Website     string   `json:"website"`
+				Topics      []string `json:"topics"`
+			}
+			apiUrl := fmt.Sprintf("https://%s/api/v1/repos/%s/%s", pkg.Host, pkg.Author, pkg.Project)
+			if err := fetchJson(apiUrl, header, &repo); err != nil {
+				return Metadata{}, err
+			}
+			return Metadata{Description: repo.Description, Homepage: repo.Website, Topics: repo.Topics}, nil
+		}
+		return Metadata{}, errors.New(fmt.Sprintf("Unsupported domain: %s", pkg.Host))
+	}
+}
+
+func fetchJson(apiUrl string, header http.Header, v interface{}) error {
+	status, body, err := fetchWithHeader(apiUrl, header)
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return fmt.Errorf("Could not fetch %s; HTTP status=%d", apiUrl, status)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// The content of a <meta name="description"> tag
+func metaDescription(n *html.Node) string {
+	if n.Type == html.ElementNode && n.Data == "meta" {
+		isDescription := false
+		content := ""
+		for _, a := range n.Attr {
+			if a.Key == "name" && a.Val == "description" {
+				isDescription = true
+			} else if a.Key == "content" {
+				content = a.Val
+			}
+		}
+		if isDescription {
+			return strings.TrimSpace(content)
+		}
+		return ""
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if ret := metaDescription(c); ret != "" {
+			return ret
+		}
+	}
+	return ""
+}
+
+var markdownImageReg = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+var markdownLinkReg = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+var markdownEmphasisReg = regexp.MustCompile("[*_`]+")
+var htmlTagReg = regexp.MustCompile(`<[^>]*>`)
+
+// The first paragraph of prose in a README, skipping headings, badges and
+// HTML, with Markdown formatting removed
+func readmeSummary(readme string) string {
+	var para []string
+	for _, line := range strings.Split(readme, "\n") {
+		line = strings.TrimSpace(line)
+		skip := line == "" ||
+			strings.HasPrefix(line, "#") ||
+			strings.HasPrefix(line, "<") ||
+			strings.HasPrefix(line, "[!") ||
+			strings.HasPrefix(line, "![") ||
+			strings.HasPrefix(line, "```") ||
+			strings.Trim(line, "=-") == ""
+		if skip {
+			if len(para) > 0 {
+				break
+			}
+			continue
+		}
+		para = append(para, line)
+	}
+	text := strings.Join(para, " ")
+	text = markdownImageReg.ReplaceAllString(text, "")
+	text = markdownLinkReg.ReplaceAllString(text, "$1")
+	text = htmlTagReg.ReplaceAllString(text, "")
+	text = markdownEmphasisReg.ReplaceAllString(text, "")
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Fill in what the forge didn't provide from the README, and drop a homepage
+// that is just the repo
+func completeMetadata(pkg Package, meta Metadata, readme string) Metadata {
+	meta.Summary = readmeSummary(readme)
+	if meta.Description == "" {
+		meta.Description = firstSentence(meta.Summary)
+	}
+	meta.Description = strings.Join(strings.Fields(meta.Description), " ")
+	homepage := strings.TrimSuffix(meta.Homepage, "/")
+	if homepage == "https://"+pkg.repo() || homepage == "http://"+pkg.repo() {
+		meta.Homepage = ""
+	}
+	return meta
+}
+
+func firstSentence(text string) string {
+	if i := strings.Index(text, ". "); i >= 0 {
+		return text[:i+1]
+	}
+	return text
+}
+
+// MacPorts asks for descriptions to be short
+const maxDescriptionLength = 60
+
+// Longer long_descriptions are truncated to whole sentences
+const maxLongDescriptionLength = 600
+
+// Shorten text to at most max characters, at a word boundary
+func truncateWords(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+	cut := strings.LastIndex(text[:max+1], " ")
+	if cut <= 0 {
+		cut = max
+	}
+	return strings.TrimRight(text[:cut], " ,;:-")
+}
+
+// Shorten text to at most max characters, at a sentence boundary if possible
+func truncateSentences(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+	if cut := strings.LastIndex(text[:max], ". "); cut > 0 {
+		return text[:cut+1]
+	}
+	return truncateWords(text, max)
+}
+
+var tclSpecialReg = regexp.MustCompile(`[\\\[\]${}";]`)
+
+// Escape characters that are special in Tcl words
+func tclEscape(text string) string {
+	return tclSpecialReg.ReplaceAllString(text, `\$0`)
+}
+
+func descriptionStr(meta Metadata) string {
+	desc := truncateWords(meta.Description, maxDescriptionLength)
+	// port lint doesn't like descriptions ending in a period
+	desc = strings.TrimSuffix(desc, ".")
+	return tclEscape(desc)
+}
+
+// long_description, wrapped to fit in 80 columns
+func longDescriptionStr(meta Metadata) string {
+	long := meta.Summary
+	if long == "" || long == meta.Description || long == firstSentence(meta.Description) {
+		if meta.Description == "" {
+			return ""
+		}
+		return "{*}${description}"
+	}
+	words := strings.Fields(tclEscape(truncateSentences(long, maxLongDescriptionLength)))
+	var lines []string
+	line := ""
+	for _, word := range words {
+		if line != "" && 20+len(line)+1+len(word) > 78 {
+			lines = append(lines, line)
+			line = word
+		} else if line == "" {
+			line = word
+		} else {
+			line += " " + word
+		}
+	}
+	lines = append(lines, line)
+	return strings.Join(lines, " \\\n                    ")
+}
@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+const readme = `# foo
+
+[![Build](https://example.com/badge.svg)](https://example.com/ci)
+
+<p align="center"><img src="logo.png"></p>
+
+**foo** is a [fast](https://example.com) tool for converting $HOME-relative
+paths. It supports ` + "`bar`" + ` and [baz] syntax.
+
+## Installation
+`
+
+func TestReadmeSummary(t *testing.T) {
+	expected := "foo is a fast tool for converting $HOME-relative paths. It supports bar and [baz] syntax."
+	if actual := readmeSummary(readme); actual != expected {
+		t.Errorf("unexpected summary: %s", actual)
+	}
+}
+
+func TestMetadataStrings(t *testing.T) {
+	pkg := Package{Host: "github.com", Author: "foo", Project: "bar"}
+	meta := completeMetadata(pkg, Metadata{Homepage: "https://github.com/foo/bar/"}, readme)
+	if meta.Homepage != "" {
+		t.Errorf("expected repo homepage to be dropped, got %s", meta.Homepage)
+	}
+	if actual := descriptionStr(meta); actual != `foo is a fast tool for converting \$HOME-relative paths` {
+		t.Errorf("unexpected description: %s", actual)
+	}
+	expected := `foo is a fast tool for converting \$HOME-relative paths. \
+                    It supports bar and \[baz\] syntax.`
+	if actual := longDescriptionStr(meta); actual != expected {
+		t.Errorf("unexpected long_description:\n%s", actual)
+	}
+
+	meta = completeMetadata(pkg, Metadata{Description: "A tool", Homepage: "https://foo.dev"}, "")
+	if descriptionStr(meta) != "A tool" || longDescriptionStr(meta) != "{*}${description}" || meta.Homepage != "https://foo.dev" {
+		t.Errorf("unexpected metadata: %+v", meta)
+	}
+
+	long := "This is a very long description of a tool that goes on and on about all the things it can do"
+	if actual := descriptionStr(Metadata{Description: long}); actual != "This is a very long description of a tool that goes on and" {
+		t.Errorf("unexpected truncated description: %s", actual)
+	}
+}
+
+func TestForgeAuthHeader(t *testing.T) {
+	defer func() { userConfig = Config{} }()
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GITLAB_TOKEN", "")
+	if header := forgeAuthHeader("github.com"); len(header) != 0 {
+		t.Errorf("expected no header without a token, got %v", header)
+	}
+	userConfig.Tokens = Tokens{GitHub: "config-token", GitLab: "gitlab-token"}
+	if actual := forgeAuthHeader("github.com").Get("Authorization"); actual != "Bearer config-token" {
+		t.Errorf("unexpected GitHub header: %s", actual)
+	}
+	if actual := forgeAuthHeader("gitlab.com").Get("PRIVATE-TOKEN"); actual != "gitlab-token" {
+		t.Errorf("unexpected GitLab header: %s", actual)
+	}
+	t.Setenv("GITHUB_TOKEN", "env-token")
+	if actual := forgeAuthHeader("github.com").Get("Authorization"); actual != "Bearer env-token" {
+		t.Errorf("expected GITHUB_TOKEN to take precedence, got %s", actual)
+	}
+	if header := forgeAuthHeader("codeberg.org"); len(header) != 0 {
+		t.Errorf("expected no header for other forges, got %v", header)
+	}
+}
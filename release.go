@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	Url  string `json:"browser_download_url"`
+}
+
+func releaseAssets(pkg Package) ([]releaseAsset, error) {
+	if pkg.Host != "github.com" {
+		return nil, fmt.Errorf("Release assets are only supported for GitHub; got %s", pkg.Host)
+	}
+	apiUrl := fmt.Sprintf("https://api.github.com/repos/%s/%s/releases/tags/%s", pkg.Author, pkg.Project, pkg.Version)
+	status, body, err := fetch(apiUrl)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		msg := fmt.Sprintf("Could not find release %s of %s; HTTP status=%d", pkg.Version, pkg.Id, status)
+		return nil, errors.New(msg)
+	}
+	var release struct {
+		Assets []releaseAsset `json:"assets"`
+	}
+	err = json.Unmarshal(body, &release)
+	if err != nil {
+		return nil, err
+	}
+	return release.Assets, nil
+}
+
+// Pick the single asset whose name matches pattern (see path.Match)
+func chooseReleaseAsset(pkg Package, pattern string) (releaseAsset, error) {
+	assets, err := releaseAssets(pkg)
+	if err != nil {
+		return releaseAsset{}, err
+	}
+	return matchReleaseAsset(assets, pattern)
+}
+
+func matchReleaseAsset(assets []releaseAsset, pattern string) (releaseAsset, error) {
+	var matches []releaseAsset
+	names := make([]string, len(assets))
+	for i, asset := range assets {
+		names[i] = asset.Name
+		ok, err := path.Match(pattern, asset.Name)
+		if err != nil {
+			return releaseAsset{}, err
+		}
+		if ok {
+			matches = append(matches, asset)
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+	var msg string
+	if len(matches) == 0 {
+		msg = fmt.Sprintf("No release asset matches %s", pattern)
+	} else {
+		msg = fmt.Sprintf("Multiple release assets match %s", pattern)
+	}
+	if len(names) == 0 {
+		return releaseAsset{}, errors.New(msg + "; the release has no assets")
+	}
+	return releaseAsset{}, fmt.Errorf("%s; choose one of:\n    %s", msg, strings.Join(names, "\n    "))
+}
+
+// Archive suffixes MacPorts can extract, and the portfile line needed to select
+// each
+var assetSuffixes = []struct {
+	suffix string
+	option string
+}{
+	{".tar.gz", ""},
+	{".tgz", "extract.suffix      .tgz"},
+	{".tar.xz", "use_xz              yes"},
+	{".tar.bz2", "use_bzip2           yes"},
+	{".zip", "use_zip             yes"},
+}
+
+// The portfile lines that point the main distfile at a release asset
+func releaseAssetDistfile(pkg Package, asset releaseAsset) (string, error) {
+	for _, s := range assetSuffixes {
+		if !strings.HasSuffix(asset.Name, s.suffix) {
+			continue
+		}
+		ret := fmt.Sprintf("master_sites        https://github.com/%s/%s/releases/download/%s/\n", pkg.Author, pkg.Project, pkg.Version)
+		ret = ret + fmt.Sprintf("distname            %s\n", strings.TrimSuffix(asset.Name, s.suffix))
+		if s.option != "" {
+			ret = ret + s.option + "\n"
+		}
+		return ret, nil
+	}
+	return "", fmt.Errorf("Unsupported release asset type: %s", asset.Name)
+}
+
+// The master_sites and distfile name (distname plus suffix) set by the lines
+// selecting the main distfile in an existing portfile, if any
+func distfileFromPortfile(portfile string) (string, string) {
+	var site, name string
+	suffix := ".tar.gz"
+	for _, line := range strings.Split(distfileLinesPattern.FindString(portfile), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "master_sites":
+			site = fields[1]
+		case "distname":
+			name = fields[1]
+		case "extract.suffix":
+			suffix = fields[1]
+		default:
+			for _, s := range assetSuffixes {
+				if s.option != "" && strings.Join(fields, " ") == strings.Join(strings.Fields(s.option), " ") {
+					suffix = s.suffix
+				}
+			}
+		}
+	}
+	if name == "" {
+		return site, ""
+	}
+	return site, name + suffix
+}
+
+// The name of the release asset an existing portfile uses as its main
+// distfile, or "" if it uses the source tarball. Other distfiles can't be
+// updated automatically.
+func releaseAssetFromPortfile(portfile string) (string, error) {
+	if !distfileLinesPattern.MatchString(portfile) {
+		return "", nil
+	}
+	site, name := distfileFromPortfile(portfile)
+	if !strings.HasPrefix(site, "https://github.com/") || !strings.Contains(site, "/releases/download/") || name == "" {
+		return "", errors.New("The portfile selects its own main distfile with master_sites or distname; update it by hand, or pass --release-asset")
+	}
+	return name, nil
+}
+
+// A pattern matching the counterpart of asset in other releases, made by
+// replacing the first of versions found in its name with a wildcard
+func releaseAssetPattern(asset string, versions ...string) (string, error) {
+	for _, v := range versions {
+		if v != "" && strings.Contains(asset, v) {
+			return strings.Replace(asset, v, "*", 1), nil
+		}
+	}
+	return "", fmt.Errorf("Could not tell the version in release asset %s; pass --release-asset", asset)
+}
+
+var distfileLinesPattern = regexp.MustCompile(`(?m)^(?:(?:master_sites|distname|extract\.suffix|use_xz|use_bzip2|use_zip)\s+.*\n)+`)
+
+// Make the lines selecting the main distfile in an existing portfile
+// replaceable when updating to a new release asset, keeping them as-is
+// otherwise
+func replaceDistfileLines(portfile string) string {
+	loc := distfileLinesPattern.FindStringIndex(portfile)
+	if loc == nil {
+		return strings.Replace(portfile, "{{.Checksums}}", "{{with .Distfile}}{{.}}\n{{end}}{{.Checksums}}", 1)
+	}
+	block := portfile[loc[0]:loc[1]]
+	return portfile[:loc[0]] + "{{if .Distfile}}{{.Distfile}}{{else}}" + block + "{{end}}" + portfile[loc[1]:]
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchReleaseAsset(t *testing.T) {
+	assets := []releaseAsset{
+		{Name: "bar-1.2.3-vendored.tar.xz", Url: "https://example.com/a"},
+		{Name: "bar_1.2.3_darwin_arm64.tar.gz", Url: "https://example.com/b"},
+		{Name: "bar_1.2.3_linux_amd64.tar.gz", Url: "https://example.com/c"},
+	}
+	asset, err := matchReleaseAsset(assets, "*-vendored.tar.xz")
+	if err != nil {
+		t.Fatalf("matchReleaseAsset failed: %v", err)
+	}
+	if asset.Url != "https://example.com/a" {
+		t.Errorf("unexpected asset: %+v", asset)
+	}
+	_, err = matchReleaseAsset(assets, "*.tar.gz")
+	if err == nil || !strings.Contains(err.Error(), "bar_1.2.3_linux_amd64.tar.gz") {
+		t.Errorf("expected ambiguity error listing assets; got %v", err)
+	}
+	_, err = matchReleaseAsset(assets, "*.zip")
+	if err == nil {
+		t.Errorf("expected error for no match")
+	}
+}
+
+func TestReleaseAssetDistfile(t *testing.T) {
+	pkg := Package{Host: "github.com", Author: "foo", Project: "bar", Version: "v1.2.3"}
+	out, err := releaseAssetDistfile(pkg, releaseAsset{Name: "bar-1.2.3-vendored.tar.xz"})
+	if err != nil {
+		t.Fatalf("releaseAssetDistfile failed: %v", err)
+	}
+	expected := `master_sites        https://github.com/foo/bar/releases/download/v1.2.3/
+distname            bar-1.2.3-vendored
+use_xz              yes
+`
+	if out != expected {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	_, err = releaseAssetDistfile(pkg, releaseAsset{Name: "bar.dmg"})
+	if err == nil {
+		t.Errorf("expected error for unsupported asset type")
+	}
+}
+
+func TestReplaceDistfileLines(t *testing.T) {
+	portfile := "go.setup            github.com/foo/bar v1.0.0\nmaster_sites        old/\ndistname            old\n\n{{.Checksums}}\n"
+	tmplate := replaceDistfileLines(portfile)
+	out := renderTemplate(t, tmplate, map[string]string{"Distfile": "distname            new\n", "Checksums": "checksums"})
+	if out != "go.setup            github.com/foo/bar v1.0.0\ndistname            new\n\nchecksums\n" {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	out = renderTemplate(t, tmplate, map[string]string{"Checksums": "checksums"})
+	if out != "go.setup            github.com/foo/bar v1.0.0\nmaster_sites        old/\ndistname            old\n\nchecksums\n" {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestReleaseAssetFromPortfile(t *testing.T) {
+	pkg := Package{Host: "github.com", Author: "foo", Project: "bar", Version: "v1.2.3"}
+	distfile, err := releaseAssetDistfile(pkg, releaseAsset{Name: "bar-1.2.3-vendored.tar.xz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	portfile := "go.setup            github.com/foo/bar 1.2.3 v\n" + distfile + "\nchecksums           rmd160  r\n"
+	asset, err := releaseAssetFromPortfile(portfile)
+	if err != nil || asset != "bar-1.2.3-vendored.tar.xz" {
+		t.Fatalf("unexpected asset: %q, %v", asset, err)
+	}
+	opts, err := optionsFromPortfile(Options{}, portfile)
+	if err != nil || opts.ReleaseAsset != "bar-*-vendored.tar.xz" {
+		t.Errorf("unexpected pattern: %q, %v", opts.ReleaseAsset, err)
+	}
+	opts, err = optionsFromPortfile(Options{ReleaseAsset: "*.tar.xz"}, portfile)
+	if err != nil || opts.ReleaseAsset != "*.tar.xz" {
+		t.Errorf("explicit pattern not kept: %q, %v", opts.ReleaseAsset, err)
+	}
+
+	if asset, err := releaseAssetFromPortfile("go.setup            github.com/foo/bar 1.2.3 v\n"); asset != "" || err != nil {
+		t.Errorf("unexpected asset for source tarball: %q, %v", asset, err)
+	}
+	custom := "master_sites        https://example.com/downloads/\ndistname            bar-1.2.3\n"
+	if _, err := releaseAssetFromPortfile(custom); err == nil {
+		t.Error("expected an error for custom master_sites")
+	}
+	if _, err := releaseAssetPattern("bar-vendored.tar.xz", "v1.2.3", "1.2.3"); err == nil {
+		t.Error("expected an error for an asset name without the version")
+	}
+}
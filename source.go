@@ -0,0 +1,484 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"go/ast"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// What we learned about the contents of a source tarball. Paths are relative
+// to the repo root, with "." for the root itself.
+type Source struct {
+	// Directories containing main packages
+	MainPackages []string `json:"mainPackages,omitempty"`
+	// Uninitialized version variables in main packages, by directory, as
+	// symbols for -ldflags -X (e.g. "main.version")
+	VersionVars map[string][]string `json:"versionVars,omitempty"`
+	// Version variables set with -ldflags -X by the Makefile or goreleaser
+	// configuration at the repo root
+	BuildVersionVars []string `json:"buildVersionVars,omitempty"`
+	// Packages that use cgo
+	Cgo []CgoPackage `json:"cgo,omitempty"`
+	// Imports of each package other than the standard library, by directory
+	Imports map[string][]string `json:"-"`
+	// Licenses by the directory containing the license file
+	Licenses map[string]LicenseMatch `json:"licenses,omitempty"`
+	// The start of README files, by directory
+	Readmes map[string]string `json:"-"`
+}
+
+// How much of a README to keep
+const maxReadmeLength = 16 * 1024
+
+var readmeFileReg = regexp.MustCompile(`^(?i)readme(\.(md|markdown|txt|rst|org))?$`)
+
+// A package that uses cgo, with the libraries it asks for on macOS
+type CgoPackage struct {
+	Dir string `json:"dir"`
+	// Modules named in #cgo pkg-config directives
+	PkgConfig []string `json:"pkgConfig,omitempty"`
+	// Libraries named with -l in #cgo LDFLAGS directives
+	Libs []string `json:"libs,omitempty"`
+}
+
+// Open a tarball as served by the supported forges
+func openTar(tarball []byte) (*tar.Reader, error) {
+	switch {
+	case bytes.HasPrefix(tarball, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(bytes.NewReader(tarball))
+		if err != nil {
+			return nil, err
+		}
+		return tar.NewReader(zr), nil
+	case bytes.HasPrefix(tarball, []byte("BZh")):
+		return tar.NewReader(bzip2.NewReader(bytes.NewReader(tarball))), nil
+	default:
+		return nil, errors.New("Unsupported archive format")
+	}
+}
+
+// What to read from a tarball besides its checksums
+type scanMode int
+
+const (
+	// License files
+	scanLicenses scanMode = 1 << iota
+	// Imports and cgo use of Go packages
+	scanImports
+	// Main packages, version variables, READMEs and build files, which only
+	// matter for the port's own source
+	scanPort
+)
+
+const (
+	// Nothing; dependencies are only checksummed unless asked otherwise
+	scanChecksums scanMode = 0
+	// Everything, for the port's own source
+	scanMain = scanLicenses | scanImports | scanPort
+)
+
+func scanSource(tarball []byte, mode scanMode) (Source, error) {
+	src := Source{}
+	tr, err := openTar(tarball)
+	if err != nil {
+		return src, err
+	}
+	mains := make(map[string]bool)
+	buildVars := make(map[string]bool)
+	cgo := make(map[string]*CgoPackage)
+	licenses := make(map[string]LicenseMatch)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return src, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		// Tarballs have a single top-level directory named for the project
+		// and revision
+		i := strings.Index(hdr.Name, "/")
+		if i < 0 {
+			continue
+		}
+		name := hdr.Name[i+1:]
+		if ignoredSourcePath(name) {
+			continue
+		}
+		dir := path.Dir(name)
+		if isLicenseFile(name) {
+			if mode&scanLicenses == 0 {
+				continue
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return src, err
+			}
+			match := classifyLicense(name, data)
+			if existing, ok := licenses[dir]; ok {
+				match = betterLicense(&existing, match)
+			}
+			if match != nil {
+				licenses[dir] = *match
+			}
+			continue
+		}
+		if mode&scanPort == 0 && (mode&scanImports == 0 || !isBuildableGoFile(name)) {
+			continue
+		}
+		if readmeFileReg.MatchString(path.Base(name)) {
+			data, err := io.ReadAll(io.LimitReader(tr, maxReadmeLength))
+			if err != nil {
+				return src, err
+			}
+			if src.Readmes == nil {
+				src.Readmes = make(map[string]string)
+			}
+			if _, ok := src.Readmes[dir]; !ok {
+				src.Readmes[dir] = string(data)
+			}
+			continue
+		}
+		if isBuildFile(name) {
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return src, err
+			}
+			for _, v := range ldflagsVersionVars(data) {
+				buildVars[v] = true
+			}
+			continue
+		}
+		if !isBuildableGoFile(name) {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return src, err
+		}
+		pkgName := goPackageName(name, data)
+		if pkgName == "" {
+			continue
+		}
+		if mode&scanImports != 0 {
+			if imports := goImports(name, data); len(imports) > 0 {
+				if src.Imports == nil {
+					src.Imports = make(map[string][]string)
+				}
+				src.Imports[dir] = appendUnique(src.Imports[dir], imports...)
+			}
+			if use, pkgConfig, libs := cgoDirectives(name, data); use {
+				c := cgo[dir]
+				if c == nil {
+					c = &CgoPackage{Dir: dir}
+					cgo[dir] = c
+				}
+				c.PkgConfig = appendUnique(c.PkgConfig, pkgConfig...)
+				c.Libs = appendUnique(c.Libs, libs...)
+			}
+		}
+		if pkgName != "main" || mode&scanPort == 0 {
+			continue
+		}
+		mains[dir] = true
+		for _, v := range uninitializedVersionVars(name, data) {
+			if src.VersionVars == nil {
+				src.VersionVars = make(map[string][]string)
+			}
+			src.VersionVars[dir] = append(src.VersionVars[dir], "main."+v)
+		}
+	}
+	for dir := range mains {
+		src.MainPackages = append(src.MainPackages, dir)
+	}
+	sort.Strings(src.MainPackages)
+	for v := range buildVars {
+		src.BuildVersionVars = append(src.BuildVersionVars, v)
+	}
+	sort.Strings(src.BuildVersionVars)
+	for _, vars := range src.VersionVars {
+		sort.Strings(vars)
+	}
+	for _, c := range cgo {
+		src.Cgo = append(src.Cgo, *c)
+	}
+	if len(licenses) > 0 {
+		src.Licenses = licenses
+	}
+	sort.Slice(src.Cgo, func(i, j int) bool { return src.Cgo[i].Dir < src.Cgo[j].Dir })
+	return src, nil
+}
+
+// Files at the repo root that commonly specify ldflags
+func isBuildFile(name string) bool {
+	switch name {
+	case "Makefile", "GNUmakefile", "makefile", ".goreleaser.yml", ".goreleaser.yaml":
+		return true
+	}
+	return false
+}
+
+var versionVarReg = regexp.MustCompile(`^(?i)(app|build|main)?_?version$`)
+
+// Package-level string variables named like "version" with no initial value,
+// which are meant to be set with -ldflags -X
+func uninitializedVersionVars(name string, data []byte) []string {
+	file, err := parser.ParseFile(token.NewFileSet(), name, data, parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+	var ret []string
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.VAR {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			vs := spec.(*ast.ValueSpec)
+			if len(vs.Values) > 0 {
+				continue
+			}
+			if typ, ok := vs.Type.(*ast.Ident); !ok || typ.Name != "string" {
+				continue
+			}
+			for _, ident := range vs.Names {
+				if versionVarReg.MatchString(ident.Name) {
+					ret = append(ret, ident.Name)
+				}
+			}
+		}
+	}
+	return ret
+}
+
+var ldflagsXReg = regexp.MustCompile(`-X[ =]?['"]?([\w./-]+\.(\w+))=`)
+
+// Symbols set with -X in a Makefile or similar whose names look like versions
+func ldflagsVersionVars(data []byte) []string {
+	var ret []string
+	for _, match := range ldflagsXReg.FindAllSubmatch(data, -1) {
+		if versionVarReg.MatchString(string(match[2])) {
+			ret = append(ret, string(match[1]))
+		}
+	}
+	return ret
+}
+
+// The nearest directory at or above dir (relative to the repo root, "" or "."
+// for the root) for which has returns true
+func nearestDir(dir string, has func(string) bool) (string, bool) {
+	if dir == "" {
+		dir = "."
+	}
+	for {
+		if has(dir) {
+			return dir, true
+		}
+		if dir == "." {
+			return "", false
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// The README nearest dir
+func (src *Source) readme(dir string) string {
+	if found, ok := nearestDir(dir, func(d string) bool { _, ok := src.Readmes[d]; return ok }); ok {
+		return src.Readmes[found]
+	}
+	return ""
+}
+
+// The imports of a Go file other than the standard library and "C"
+func goImports(name string, data []byte) []string {
+	file, err := parser.ParseFile(token.NewFileSet(), name, data, parser.ImportsOnly)
+	if err != nil {
+		return nil
+	}
+	var ret []string
+	for _, imp := range file.Imports {
+		p := strings.Trim(imp.Path.Value, `"`)
+		// Standard library paths have no dot in the first element
+		if first, _, _ := strings.Cut(p, "/"); strings.Contains(first, ".") {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+var cgoDirectiveReg = regexp.MustCompile(`^#cgo\s+([^:]*?)\s*(pkg-config|LDFLAGS):(.*)$`)
+
+// Whether a Go file imports "C", and the pkg-config modules and libraries its
+// #cgo directives ask for on macOS
+func cgoDirectives(name string, data []byte) (bool, []string, []string) {
+	file, err := parser.ParseFile(token.NewFileSet(), name, data, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return false, nil, nil
+	}
+	use := false
+	var pkgConfig, libs []string
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			imp := spec.(*ast.ImportSpec)
+			if imp.Path.Value != `"C"` {
+				continue
+			}
+			use = true
+			doc := imp.Doc
+			if doc == nil {
+				doc = gen.Doc
+			}
+			if doc == nil {
+				continue
+			}
+			for _, line := range strings.Split(doc.Text(), "\n") {
+				match := cgoDirectiveReg.FindStringSubmatch(strings.TrimSpace(line))
+				if match == nil || !cgoConditionSatisfied(match[1]) {
+					continue
+				}
+				for _, arg := range strings.Fields(match[3]) {
+					if match[2] == "pkg-config" {
+						if !strings.HasPrefix(arg, "-") {
+							pkgConfig = appendUnique(pkgConfig, arg)
+						}
+					} else if strings.HasPrefix(arg, "-l") && len(arg) > 2 {
+						libs = appendUnique(libs, arg[2:])
+					}
+				}
+			}
+		}
+	}
+	return use, pkgConfig, libs
+}
+
+// Whether the build constraints of a #cgo directive (e.g. "darwin,!arm64 linux")
+// are satisfied on macOS
+func cgoConditionSatisfied(cond string) bool {
+	options := strings.Fields(cond)
+	if len(options) == 0 {
+		return true
+	}
+	for _, option := range options {
+		ok := true
+		for _, term := range strings.Split(option, ",") {
+			if strings.HasPrefix(term, "!") {
+				ok = ok && !satisfiedTag(term[1:])
+			} else {
+				ok = ok && satisfiedTag(term)
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, existing := range list {
+			if existing == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Directories the go tool ignores, plus vendored code
+func ignoredSourcePath(name string) bool {
+	elems := strings.Split(name, "/")
+	for _, elem := range elems[:len(elems)-1] {
+		if elem == "testdata" || elem == "vendor" || strings.HasPrefix(elem, "_") || strings.HasPrefix(elem, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+var knownOS = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true, "freebsd": true,
+	"hurd": true, "illumos": true, "ios": true, "js": true, "linux": true, "nacl": true,
+	"netbsd": true, "openbsd": true, "plan9": true, "solaris": true, "wasip1": true,
+	"windows": true, "zos": true,
+}
+
+var knownArch = map[string]bool{
+	"386": true, "amd64": true, "arm": true, "arm64": true, "loong64": true,
+	"mips": true, "mipsle": true, "mips64": true, "mips64le": true, "ppc64": true,
+	"ppc64le": true, "riscv64": true, "s390x": true, "wasm": true,
+}
+
+// Build tags satisfied when building on macOS
+func satisfiedTag(tag string) bool {
+	switch tag {
+	case "darwin", "unix", "amd64", "arm64", "gc", "cgo":
+		return true
+	}
+	return strings.HasPrefix(tag, "go1.")
+}
+
+// Whether a (non-test) Go file would be built on macOS, judging by its name
+func isBuildableGoFile(name string) bool {
+	base := path.Base(name)
+	if !strings.HasSuffix(base, ".go") || strings.HasSuffix(base, "_test.go") {
+		return false
+	}
+	parts := strings.Split(strings.TrimSuffix(base, ".go"), "_")
+	n := len(parts)
+	if n >= 3 && knownOS[parts[n-2]] && knownArch[parts[n-1]] {
+		return satisfiedTag(parts[n-2]) && satisfiedTag(parts[n-1])
+	}
+	if n >= 2 && (knownOS[parts[n-1]] || knownArch[parts[n-1]]) {
+		return satisfiedTag(parts[n-1])
+	}
+	return true
+}
+
+// The package name of a Go file, or "" if its build constraints exclude it on
+// macOS (or it can't be parsed)
+func goPackageName(name string, data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+		if !constraint.IsGoBuild(line) {
+			continue
+		}
+		expr, err := constraint.Parse(line)
+		if err == nil && !expr.Eval(satisfiedTag) {
+			return ""
+		}
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), name, data, parser.PackageClauseOnly)
+	if err != nil {
+		return ""
+	}
+	return file.Name.Name
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Build a gzipped tarball with the given files under a top-level directory, as
+// served by GitHub
+func makeTarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for name, content := range files {
+		hdr := &tar.Header{
+			Name:     "foo-bar-abcdef0/" + name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestScanSourceMainPackages(t *testing.T) {
+	tarball := makeTarball(t, map[string]string{
+		"main.go":                  "package main\n",
+		"lib/lib.go":               "package lib\n",
+		"cmd/foo/main.go":          "// Copyright\n\npackage main\n",
+		"cmd/bar/main_windows.go":  "package main\n",
+		"cmd/baz/main.go":          "//go:build linux\n\npackage main\n",
+		"cmd/qux/main.go":          "//go:build darwin || linux\n\npackage main\n",
+		"internal/gen/gen.go":      "//go:build ignore\n\npackage main\n",
+		"internal/gen/doc.go":      "package gen\n",
+		"testdata/x/main.go":       "package main\n",
+		"_examples/main.go":        "package main\n",
+		"cmd/foo/main_test.go":     "package main\n",
+		"cmd/quux/main_darwin.go":  "package main\n",
+		"cmd/quux/main_arm64.go":   "package main\n",
+		"cmd/corge/main_linux.go":  "package main\n",
+		"cmd/corge/other_amd64.go": "package corge\n",
+	})
+	src, err := scanSource(tarball, scanMain)
+	if err != nil {
+		t.Fatalf("scanSource failed: %v", err)
+	}
+	expected := []string{".", "cmd/foo", "cmd/quux", "cmd/qux"}
+	if !reflect.DeepEqual(src.MainPackages, expected) {
+		t.Errorf("unexpected main packages: %v", src.MainPackages)
+	}
+}
+
+func TestNewBuild(t *testing.T) {
+	pkg := Package{Id: "github.com/foo/bar", ResolvedId: "github.com/foo/bar"}
+
+	build, err := newBuild(pkg, &Source{MainPackages: []string{"."}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build.String() != "" || build.destrootStr() != "destroot {\n    xinstall -m 0755 ${worksrcpath}/${name} ${destroot}${prefix}/bin/\n}" {
+		t.Errorf("unexpected default build:\n%s\n%s", build.String(), build.destrootStr())
+	}
+
+	build, err = newBuild(pkg, &Source{MainPackages: []string{".", "cmd/foo"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build.String() != "build.args          -o ${worksrcpath}/bin/ . ./cmd/foo" {
+		t.Errorf("unexpected build: %s", build.String())
+	}
+	expected := `destroot {
+    xinstall -m 0755 ${worksrcpath}/bin/bar ${destroot}${prefix}/bin/
+    xinstall -m 0755 ${worksrcpath}/bin/foo ${destroot}${prefix}/bin/
+}`
+	if build.destrootStr() != expected {
+		t.Errorf("unexpected destroot:\n%s", build.destrootStr())
+	}
+
+	build, err = newBuild(pkg, &Source{MainPackages: []string{".", "cmd/foo", "cmd/baz"}}, []string{"baz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(build.Binaries, []string{"baz"}) {
+		t.Errorf("unexpected binaries: %v", build.Binaries)
+	}
+	_, err = newBuild(pkg, &Source{MainPackages: []string{"."}}, []string{"nope"})
+	if err == nil {
+		t.Errorf("expected error for unknown command")
+	}
+
+	// Module in a subdirectory
+	pkg = Package{Id: "github.com/foo/bar/tools", ResolvedId: "github.com/foo/bar/tools", Dir: "tools"}
+	build, err = newBuild(pkg, &Source{MainPackages: []string{".", "tools/cmd/a", "tools/cmd/b"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build.String() != "build.dir           ${worksrcpath}/tools\nbuild.args          -o ${worksrcpath}/bin/ ./cmd/a ./cmd/b" {
+		t.Errorf("unexpected build:\n%s", build.String())
+	}
+}
+
+func TestNewBuildErrors(t *testing.T) {
+	pkg := Package{Id: "github.com/foo/bar", ResolvedId: "github.com/foo/bar"}
+	_, err := newBuild(pkg, &Source{MainPackages: []string{"cmd/a/tool", "cmd/b/tool"}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "both be installed as tool") {
+		t.Errorf("expected an error for clashing binaries; got %v", err)
+	}
+	_, err = newBuild(pkg, nil, []string{"tool"})
+	if err == nil || !strings.Contains(err.Error(), "Could not read the source") {
+		t.Errorf("expected an error for --cmd without source; got %v", err)
+	}
+	_, err = newBuild(pkg, &Source{}, []string{"tool"})
+	if err == nil || !strings.Contains(err.Error(), "no main packages") {
+		t.Errorf("expected an error for --cmd without main packages; got %v", err)
+	}
+}
+
+func TestScanSourceDependency(t *testing.T) {
+	tarball := makeTarball(t, map[string]string{
+		"LICENSE":    mitLicense,
+		"README.md":  "# Foo\n",
+		"main.go":    "package main\n\nvar version string\n",
+		"cgo/cgo.go": "package cgo\n\n// #cgo LDFLAGS: -lfoo\nimport \"C\"\n",
+		"Makefile":   "LDFLAGS := -X main.version=$(VERSION)\n",
+		"lib/lib.go": "package lib\n\nimport (\n\t\"fmt\"\n\t\"github.com/x/y/z\"\n)\n",
+	})
+	src, err := scanSource(tarball, scanLicenses|scanImports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Readmes != nil || src.MainPackages != nil || src.VersionVars != nil || src.BuildVersionVars != nil {
+		t.Errorf("dependency scan collected too much: %+v", src)
+	}
+	if len(src.Cgo) != 1 || src.Licenses["."].Spdx != "MIT" {
+		t.Errorf("dependency scan missed licenses or cgo: %+v", src)
+	}
+	if !reflect.DeepEqual(src.Imports, map[string][]string{"lib": {"github.com/x/y/z"}}) {
+		t.Errorf("unexpected imports: %v", src.Imports)
+	}
+
+	// Each kind of scan only reads what it's for
+	src, err = scanSource(tarball, scanLicenses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Cgo != nil || src.Imports != nil || src.Licenses["."].Spdx != "MIT" {
+		t.Errorf("unexpected license scan: %+v", src)
+	}
+	src, err = scanSource(tarball, scanImports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Licenses != nil || len(src.Cgo) != 1 || len(src.Imports) != 1 {
+		t.Errorf("unexpected import scan: %+v", src)
+	}
+}
+
+func TestNewBuildSubdirectoryRoot(t *testing.T) {
+	pkg := Package{Id: "github.com/foo/bar/cmd/tool", ResolvedId: "github.com/foo/bar/cmd/tool", Dir: "cmd/tool"}
+	build, err := newBuild(pkg, &Source{MainPackages: []string{"cmd/tool"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build.String() != "build.dir           ${worksrcpath}/cmd/tool\nbuild.args          -o ${worksrcpath}/bin/ ." {
+		t.Errorf("unexpected build:\n%s", build.String())
+	}
+	if !reflect.DeepEqual(build.Binaries, []string{"tool"}) {
+		t.Errorf("unexpected binaries: %v", build.Binaries)
+	}
+}
+
+func TestVersionVars(t *testing.T) {
+	tarball := makeTarball(t, map[string]string{
+		"main.go":         "package main\n\nvar (\n\tversion string\n\tcommit  string\n\tname    = \"foo\"\n)\n\nfunc main() {}\n",
+		"cmd/bar/main.go": "package main\n\nvar Version = \"dev\"\n\nfunc main() {}\n",
+		"Makefile":        "LDFLAGS := -X 'github.com/foo/bar/internal/build.Version=$(VERSION)' -X main.commit=$(COMMIT)\n",
+	})
+	src, err := scanSource(tarball, scanMain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src.VersionVars, map[string][]string{".": {"main.version"}}) {
+		t.Errorf("unexpected version vars: %v", src.VersionVars)
+	}
+	if !reflect.DeepEqual(src.BuildVersionVars, []string{"github.com/foo/bar/internal/build.Version"}) {
+		t.Errorf("unexpected build version vars: %v", src.BuildVersionVars)
+	}
+
+	pkg := Package{Id: "github.com/foo/bar", ResolvedId: "github.com/foo/bar"}
+	build, err := newBuild(pkg, &Source{MainPackages: []string{"."}, VersionVars: src.VersionVars}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build.String() != `build.args          -ldflags "'-X main.version=${version}'"` {
+		t.Errorf("unexpected build: %s", build.String())
+	}
+
+	src.MainPackages = []string{".", "cmd/bar"}
+	build, err = newBuild(pkg, &src, []string{"cmd/bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `build.args          -ldflags "'-X github.com/foo/bar/internal/build.Version=${version}'" -o ${worksrcpath}/bin/ ./cmd/bar`
+	if build.String() != expected {
+		t.Errorf("unexpected build: %s", build.String())
+	}
+}
+
+func TestScanSourceCgo(t *testing.T) {
+	tarball := makeTarball(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+		"db/sqlite.go": `package db
+
+// #cgo pkg-config: sqlite3
+// #cgo linux LDFLAGS: -lrt
+// #cgo darwin LDFLAGS: -lz -framework CoreFoundation
+// #include <sqlite3.h>
+import "C"
+`,
+		"db/sqlite_windows.go": "package db\n\n// #cgo LDFLAGS: -lws2_32\nimport \"C\"\n",
+		"gui/gui.go": `//go:build linux
+
+package gui
+
+// #cgo pkg-config: gtk+-3.0
+import "C"
+`,
+	})
+	src, err := scanSource(tarball, scanMain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []CgoPackage{{Dir: "db", PkgConfig: []string{"sqlite3"}, Libs: []string{"z"}}}
+	if !reflect.DeepEqual(src.Cgo, expected) {
+		t.Errorf("unexpected cgo packages: %+v", src.Cgo)
+	}
+
+	ports, unknown := cgoDependencies(append(src.Cgo, CgoPackage{Dir: "x", Libs: []string{"m", "frobnicate"}}))
+	if !reflect.DeepEqual(ports, []string{"sqlite3", "zlib"}) {
+		t.Errorf("unexpected ports: %v", ports)
+	}
+	if !reflect.DeepEqual(unknown, []string{"-lfrobnicate"}) {
+		t.Errorf("unexpected unknown libraries: %v", unknown)
+	}
+	if dependsLibStr(ports) != "depends_lib-append  port:sqlite3 \\\n                    port:zlib" {
+		t.Errorf("unexpected depends_lib: %s", dependsLibStr(ports))
+	}
+}
+
+func TestApplyCgo(t *testing.T) {
+	pkg := Package{Id: "github.com/foo/bar", ResolvedId: "github.com/foo/bar"}
+	build := Build{}
+	ports, _ := applyCgo(&build, pkg, &Source{MainPackages: []string{"."}}, nil)
+	if ports != nil || build.String() != "build.env-append    CGO_ENABLED=0" {
+		t.Errorf("unexpected pure Go build: %v\n%s", ports, build.String())
+	}
+
+	build = Build{}
+	src := &Source{
+		MainPackages: []string{"."},
+		Cgo:          []CgoPackage{{Dir: "db", Libs: []string{"sqlite3"}}},
+		Imports:      map[string][]string{".": {"github.com/foo/bar/db"}},
+	}
+	ports, _ = applyCgo(&build, pkg, src, nil)
+	if !reflect.DeepEqual(ports, []string{"sqlite3"}) || len(build.Env) != 0 {
+		t.Errorf("unexpected cgo build: %v %v", ports, build.Env)
+	}
+
+	// cgo in a package the build doesn't import
+	build = Build{}
+	src = &Source{MainPackages: []string{"."}, Cgo: []CgoPackage{{Dir: "db", Libs: []string{"sqlite3"}}}}
+	ports, _ = applyCgo(&build, pkg, src, nil)
+	if ports != nil || build.String() != "build.env-append    CGO_ENABLED=0" {
+		t.Errorf("unexpected build with unused cgo package: %v\n%s", ports, build.String())
+	}
+
+	// Only the imported packages of a vendored repo count
+	vendor := Vendor{
+		Dependency: Dependency{Name: "golang.org/x/sys", Version: "v0.1.0"},
+		Package:    Package{Host: "github.com", Author: "golang", Project: "sys", Id: "golang.org/x/sys", Version: "v0.1.0"},
+		TarballUrl: "https://github.com/golang/sys/tarball/v0.1.0",
+		Source: &Source{
+			Cgo:     []CgoPackage{{Dir: "unix", Libs: []string{"frobnicate"}}},
+			Imports: map[string][]string{"cpu": {"golang.org/x/sys/internal"}},
+		},
+	}
+	build = Build{}
+	src = &Source{MainPackages: []string{"."}, Imports: map[string][]string{".": {"golang.org/x/sys/cpu"}}}
+	ports, _ = applyCgo(&build, pkg, src, []Vendor{vendor})
+	if ports != nil || build.String() != "build.env-append    CGO_ENABLED=0" {
+		t.Errorf("unexpected build with unused vendor cgo package: %v\n%s", ports, build.String())
+	}
+	build = Build{}
+	src = &Source{MainPackages: []string{"."}, Imports: map[string][]string{".": {"golang.org/x/sys/unix"}}}
+	_, warnings := applyCgo(&build, pkg, src, []Vendor{vendor})
+	if len(warnings) != 1 || len(build.Env) != 0 {
+		t.Errorf("unexpected build with vendor cgo package: %v %v", warnings, build.Env)
+	}
+
+	// A vendor whose source could not be read may use cgo
+	unread := Vendor{Dependency: Dependency{Name: "github.com/foo/unread", Version: "v1.0.0"}}
+	build = Build{}
+	ports, warnings = applyCgo(&build, pkg, src, []Vendor{vendor, unread})
+	if ports != nil || len(build.Env) != 0 || len(warnings) != 1 || !strings.Contains(warnings[0], "github.com/foo/unread") {
+		t.Errorf("unexpected build with unread vendor: %v %v %q", ports, build.Env, warnings)
+	}
+
+	// Unknown contents
+	build = Build{}
+	ports, _ = applyCgo(&build, pkg, nil, nil)
+	if ports != nil || len(build.Env) != 0 {
+		t.Errorf("unexpected build for unknown source: %v %v", ports, build.Env)
+	}
+}
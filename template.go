@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// The data available to portfile templates. The string fields are ready-made
+// Portfile lines or values, as used by the built-in template; the rest is the
+// structured data they are rendered from, for templates that lay things out
+// differently.
+type TemplateData struct {
+	// go.setup arguments
+	PackageId string
+	Version   string
+	TagPrefix string
+	// go.package line, if the module path differs from the repo
+	PackageAlias string
+	// github.tarball_from line, if needed
+	TarballFrom string
+	// master_sites/distname lines for a release asset
+	Distfile string
+
+	Categories       string
+	Maintainers      string
+	MaintainerEmail  string
+	MaintainerGitHub string
+
+	// MacPorts license keyword, and a comment if it is uncertain
+	License     string
+	LicenseNote string
+	// Comment listing vendored modules' licenses, with --vendor-licenses
+	VendorLicenses string
+
+	// Tcl-escaped
+	Description     string
+	LongDescription string
+	Homepage        string
+
+	Checksums    string
+	GoVendors    string
+	DependsBuild string
+	DependsLib   string
+	Build        string
+	Destroot     string
+
+	// Everything go2port determined, as emitted with --format json
+	Result *Result
+	// Shortcuts into Result
+	Package      Package
+	Dependencies []Vendor
+	Binaries     []string
+	// Identity from the user config
+	Maintainer Maintainer
+}
+
+// Width of the keyword column in Portfiles
+const portfileColumn = 20
+
+// Functions available to portfile templates
+var templateFuncs = template.FuncMap{
+	// Escape Tcl special characters
+	"tcl": tclEscape,
+	// A Portfile line with value aligned to the usual column, e.g.
+	// {{field "homepage" .Homepage}}
+	"field": func(keyword string, value string) string {
+		if len(keyword) >= portfileColumn {
+			return keyword + " " + value
+		}
+		return keyword + strings.Repeat(" ", portfileColumn-len(keyword)) + value
+	},
+	// Join values with Tcl line continuations, indenting continued lines by
+	// indent spaces
+	"continued": func(indent int, values []string) string {
+		return strings.Join(values, " \\\n"+strings.Repeat(" ", indent))
+	},
+	"join":       strings.Join,
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"hasPrefix":  strings.HasPrefix,
+	"trimPrefix": strings.TrimPrefix,
+	"base":       path.Base,
+	"direct":     func(vendors []Vendor) []Vendor { return filterVendors(vendors, false) },
+	"indirect":   func(vendors []Vendor) []Vendor { return filterVendors(vendors, true) },
+	// Vendors sorted by module path, rather than the default reverse order
+	"sortVendors": func(vendors []Vendor) []Vendor {
+		ret := append([]Vendor{}, vendors...)
+		sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+		return ret
+	},
+}
+
+// Parse a portfile template, which can use and override the default blocks
+func parseTemplate(tmplate string) (*template.Template, error) {
+	blocks, err := defaultTemplates.Clone()
+	if err != nil {
+		return nil, err
+	}
+	tplt, err := blocks.New("portfile").Parse(tmplate)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid template: %w", err)
+	}
+	return tplt, nil
+}
+
+// Default layouts of the go.vendors and checksums blocks. Custom templates can
+// use them, e.g. {{template "go.vendors" (sortVendors .Dependencies)}}, or
+// redefine them.
+const blocksTemplate = `{{define "go.vendors"}}{{if .}}go.vendors          {{range $i, $v := .}}{{if $i}} \
+                    {{end}}{{template "go.vendor" $v}}{{end}}{{end}}{{end}}
+{{define "go.vendor"}}{{.Package.Id}} \
+{{if ne .Package.Id .Package.ResolvedId}}                        repo    {{.Package.ResolvedId}} \
+{{end}}                        lock    {{.Package.Version}} \
+{{template "vendor.checksums" .Checksums}}{{end}}
+{{define "vendor.checksums"}}                        rmd160  {{.Rmd160}} \
+                        sha256  {{.Sha256}} \
+                        size    {{.Size}}{{end}}
+{{define "go.vendors.grouped"}}{{with direct .}}{{template "go.vendors" .}}{{end}}{{with indirect .}}{{if direct $}}
+
+{{end}}` + indirectHeading + `
+{{template "go.vendors" .}}{{end}}{{end}}
+{{define "checksums"}}{{if .Dependencies}}checksums           ${distname}${extract.suffix} \
+{{template "vendor.checksums" .Checksums}}{{else}}checksums           rmd160  {{.Checksums.Rmd160}} \
+                    sha256  {{.Checksums.Sha256}} \
+                    size    {{.Checksums.Size}}{{end}}{{end}}`
+
+// Comment above the go.vendors block of indirect dependencies
+const indirectHeading = "# Indirect dependencies"
+
+var defaultTemplates = template.Must(template.New("blocks").Funcs(templateFuncs).Parse(blocksTemplate))
+
+// Render one of the blocks of a parsed portfile template, which are the
+// defaults unless the template redefines them
+func executeBlock(tplt *template.Template, name string, data interface{}) (string, error) {
+	var buf strings.Builder
+	err := tplt.ExecuteTemplate(&buf, name, data)
+	return buf.String(), err
+}
+
+func filterVendors(vendors []Vendor, indirect bool) []Vendor {
+	var ret []Vendor
+	for _, vendor := range vendors {
+		if vendor.Indirect == indirect {
+			ret = append(ret, vendor)
+		}
+	}
+	return ret
+}
+
+// The block rendering go.vendors, optionally split into direct and indirect
+// dependencies
+func vendorsBlockName(groupIndirect bool) string {
+	if groupIndirect {
+		return "go.vendors.grouped"
+	}
+	return "go.vendors"
+}
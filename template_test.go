@@ -0,0 +1,144 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Render one of the default blocks
+func renderBlock(name string, data interface{}) string {
+	ret, err := executeBlock(defaultTemplates, name, data)
+	if err != nil {
+		// The default blocks are fixed, so this is a bug
+		panic(err)
+	}
+	return ret
+}
+
+// The default go.vendors block
+func vendorsBlock(vendors []Vendor, groupIndirect bool) string {
+	return renderBlock(vendorsBlockName(groupIndirect), vendors)
+}
+
+func vendorsString(vendors []Vendor) string {
+	return renderBlock("go.vendors", vendors)
+}
+
+func checksumsStr(result *Result) string {
+	return renderBlock("checksums", result)
+}
+
+func TestDefaultTemplate(t *testing.T) {
+	tplt, err := parseTemplate(portfileTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf strings.Builder
+	data := TemplateData{
+		PackageId:   "github.com/foo/bar",
+		Version:     "1.0.0",
+		License:     "MIT",
+		Maintainers: "{foo @foo} openmaintainer",
+		Description: "A tool",
+		Homepage:    "https://foo.dev",
+		Checksums:   "checksums           rmd160  0",
+		Destroot:    "destroot {}",
+	}
+	if err := tplt.Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"go.setup            github.com/foo/bar 1.0.0\n",
+		"maintainers         {foo @foo} openmaintainer\n",
+		"license             MIT\n",
+		"description         A tool\n",
+		"homepage            https://foo.dev\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("expected %q in:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	tplt, err := parseTemplate(`{{field "homepage" (tcl .Package.Project)}}
+{{field "depends_lib-append" (continued 20 .Binaries)}}
+{{range .Dependencies}}{{base .Name}} {{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf strings.Builder
+	data := TemplateData{
+		Package:      Package{Project: "[bar]"},
+		Binaries:     []string{"port:a", "port:b"},
+		Dependencies: []Vendor{{Dependency: Dependency{Name: "github.com/x/y"}}},
+	}
+	if err := tplt.Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	expected := `homepage            \[bar\]
+depends_lib-append  port:a \
+                    port:b
+y `
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	vendors := []Vendor{
+		{Dependency: Dependency{Name: "example.com/b"}, Package: Package{Id: "example.com/b", ResolvedId: "github.com/x/b", Version: "v1.0.0"}, Checksums: Checksums{"r1", "s1", "1"}},
+		{Dependency: Dependency{Name: "example.com/a"}, Package: Package{Id: "example.com/a", ResolvedId: "example.com/a", Version: "abc"}, Checksums: Checksums{"r2", "s2", "2"}},
+	}
+	expected := `go.vendors          example.com/b \
+                        repo    github.com/x/b \
+                        lock    v1.0.0 \
+                        rmd160  r1 \
+                        sha256  s1 \
+                        size    1 \
+                    example.com/a \
+                        lock    abc \
+                        rmd160  r2 \
+                        sha256  s2 \
+                        size    2`
+	if actual := vendorsString(vendors); actual != expected {
+		t.Errorf("unexpected go.vendors:\n%s", actual)
+	}
+	if actual := vendorsString(nil); actual != "" {
+		t.Errorf("expected empty go.vendors, got %s", actual)
+	}
+
+	csums := Checksums{"r", "s", "3"}
+	expected = `checksums           ${distname}${extract.suffix} \
+                        rmd160  r \
+                        sha256  s \
+                        size    3`
+	if actual := checksumsStr(&Result{Checksums: csums, Dependencies: vendors}); actual != expected {
+		t.Errorf("unexpected checksums:\n%s", actual)
+	}
+	expected = `checksums           rmd160  r \
+                    sha256  s \
+                    size    3`
+	if actual := checksumsStr(&Result{Checksums: csums}); actual != expected {
+		t.Errorf("unexpected checksums:\n%s", actual)
+	}
+
+	// Templates can reorder and restyle the blocks
+	tplt, err := parseTemplate(`{{define "go.vendor"}}{{.Name}} {{.Package.Version}}{{end}}{{template "go.vendors" (sortVendors .Dependencies)}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf strings.Builder
+	if err := tplt.Execute(&buf, TemplateData{Dependencies: vendors}); err != nil {
+		t.Fatal(err)
+	}
+	expected = `go.vendors          example.com/a abc \
+                    example.com/b v1.0.0`
+	if buf.String() != expected {
+		t.Errorf("unexpected custom go.vendors:\n%s", buf.String())
+	}
+	// ...without affecting the defaults
+	if !strings.HasPrefix(vendorsString(vendors), "go.vendors          example.com/b \\\n") {
+		t.Errorf("default go.vendors was modified")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+// The newest Go provided by the MacPorts go port, as major.minor or
+// major.minor.patch. Set with --macports-go; if not set, the installed
+// MacPorts is asked on first use.
+var macportsGo = ""
+var macportsGoOnce sync.Once
+
+var portVersionReg = regexp.MustCompile(`(?m)^version:\s*(\S+)`)
+
+// Ask the installed MacPorts for the version of its go port. Tests replace it
+// so they don't depend on MacPorts.
+var queryMacportsGo = func() (string, error) {
+	out, err := exec.Command("port", "info", "--version", "go").Output()
+	if err != nil {
+		return "", err
+	}
+	if match := portVersionReg.FindSubmatch(out); match != nil {
+		return string(match[1]), nil
+	}
+	return "", fmt.Errorf("Unexpected output of port info: %s", out)
+}
+
+// The version of the MacPorts go port, or "" if it can't be determined
+func currentMacportsGo() string {
+	macportsGoOnce.Do(func() {
+		if macportsGo != "" {
+			return
+		}
+		version, err := queryMacportsGo()
+		if err != nil {
+			if debugOn {
+				log.Printf("Could not query the MacPorts go port: %s", err)
+			}
+			return
+		}
+		macportsGo = version
+	})
+	return macportsGo
+}
+
+// The Go version a module asks for in its go.mod
+type GoRequirement struct {
+	// The go directive: the minimum version that can build the module
+	Go string `json:"go,omitempty"`
+	// The toolchain directive, without its go prefix: the version the module
+	// prefers to be built with
+	Toolchain string `json:"toolchain,omitempty"`
+}
+
+func readGoRequirement(data []byte) (GoRequirement, error) {
+	req := GoRequirement{}
+	file, err := modfile.Parse("go.mod", data, nil)
+	if err != nil {
+		return req, err
+	}
+	if file.Go != nil {
+		req.Go = file.Go.Version
+	}
+	if file.Toolchain != nil {
+		req.Toolchain = strings.TrimPrefix(file.Toolchain.Name, "go")
+	}
+	return req, nil
+}
+
+var goVersionReg = regexp.MustCompile(`^(?:go)?(\d+)\.(\d+)(?:\.(\d+))?(?:(rc|beta)(\d+))?$`)
+
+// Convert a Go version like "1.22.3" or "go1.21rc1" to semver, or "" if it
+// isn't one
+func goVersionSemver(version string) string {
+	match := goVersionReg.FindStringSubmatch(version)
+	if match == nil {
+		return ""
+	}
+	patch := match[3]
+	if patch == "" {
+		patch = "0"
+	}
+	ret := fmt.Sprintf("v%s.%s.%s", match[1], match[2], patch)
+	if match[4] != "" {
+		ret += "-" + match[4] + match[5]
+	}
+	return ret
+}
+
+// Whether the MacPorts go port at version current can build a module that
+// needs Go version required. If current doesn't specify a patch version it is
+// assumed to be the latest one.
+func goSatisfies(current string, required string) bool {
+	cur := goVersionSemver(current)
+	req := goVersionSemver(required)
+	if cur == "" || req == "" {
+		return true
+	}
+	if goVersionReg.FindStringSubmatch(current)[3] == "" {
+		return semver.Compare(semver.MajorMinor(cur), semver.MajorMinor(req)) >= 0
+	}
+	return semver.Compare(cur, req) >= 0
+}
+
+// The depends_build line needed to build a module with the given requirement,
+// and a warning if the go port is too old. A newer toolchain is only warned
+// about: the golang PortGroup builds with GOTOOLCHAIN=local, so it is never
+// downloaded.
+func goRequirementDepends(req GoRequirement) (string, string) {
+	if req.Go == "" {
+		return "", ""
+	}
+	current := currentMacportsGo()
+	if current == "" {
+		return "", fmt.Sprintf("Could not determine the version of the MacPorts go port to check go.mod's Go %s against; pass --macports-go", req.Go)
+	}
+	if !goSatisfies(current, req.Go) {
+		msg := fmt.Sprintf("go.mod requires Go %s but the MacPorts go port provides %s; using go-devel", req.Go, current)
+		return "depends_build-replace port:go port:go-devel", msg
+	}
+	if req.Toolchain != "" && !goSatisfies(current, req.Toolchain) {
+		return "", fmt.Sprintf("go.mod prefers the Go %s toolchain but the MacPorts go port provides %s; check that the port builds with it", req.Toolchain, current)
+	}
+	return "", ""
+}
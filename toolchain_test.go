@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Tests never ask the installed MacPorts; those that need a go port version
+// set one
+func init() {
+	queryMacportsGo = func() (string, error) {
+		return "", errors.New("MacPorts is not queried in tests")
+	}
+}
+
+// Use version as the MacPorts go port's for the rest of the test, as if
+// returned by port info
+func fakeMacportsGo(t *testing.T, version string) {
+	savedGo, savedQuery := macportsGo, queryMacportsGo
+	t.Cleanup(func() {
+		macportsGo, queryMacportsGo = savedGo, savedQuery
+		macportsGoOnce = sync.Once{}
+	})
+	macportsGo = ""
+	macportsGoOnce = sync.Once{}
+	queryMacportsGo = func() (string, error) { return version, nil }
+}
+
+func TestGoRequirement(t *testing.T) {
+	req, err := readGoRequirement([]byte("module example.com/foo\n\ngo 1.24.2\n\ntoolchain go1.25.1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Go != "1.24.2" || req.Toolchain != "1.25.1" {
+		t.Errorf("unexpected requirement: %+v", req)
+	}
+
+	tests := []struct {
+		current  string
+		required string
+		expected bool
+	}{
+		{"1.25", "1.25.3", true},
+		{"1.25", "1.24", true},
+		{"1.25", "1.26", false},
+		{"1.25", "1.26rc1", false},
+		{"1.25.1", "1.25.3", false},
+		{"1.25.1", "1.25.1", true},
+	}
+	for _, test := range tests {
+		if actual := goSatisfies(test.current, test.required); actual != test.expected {
+			t.Errorf("goSatisfies(%s, %s): expected %v", test.current, test.required, test.expected)
+		}
+	}
+
+	fakeMacportsGo(t, "1.25")
+	if depends, msg := goRequirementDepends(GoRequirement{Go: "1.24"}); depends != "" || msg != "" {
+		t.Errorf("unexpected depends for satisfied requirement: %s", depends)
+	}
+	if depends, msg := goRequirementDepends(GoRequirement{Go: "1.26.0"}); depends != "depends_build-replace port:go port:go-devel" || msg == "" {
+		t.Errorf("unexpected depends for unsatisfied requirement: %s", depends)
+	}
+	if depends, msg := goRequirementDepends(GoRequirement{Go: "1.24", Toolchain: "1.25.1"}); depends != "" || msg != "" {
+		t.Errorf("unexpected result for satisfied toolchain: %s %s", depends, msg)
+	}
+	if depends, msg := goRequirementDepends(GoRequirement{Go: "1.24", Toolchain: "1.26.1"}); depends != "" || !strings.Contains(msg, "Go 1.26.1 toolchain") {
+		t.Errorf("unexpected result for newer toolchain: %s %s", depends, msg)
+	}
+}
+
+func TestCurrentMacportsGo(t *testing.T) {
+	fakeMacportsGo(t, "1.25.3")
+	if current := currentMacportsGo(); current != "1.25.3" {
+		t.Errorf("unexpected version: %s", current)
+	}
+
+	// --macports-go takes precedence over port info
+	fakeMacportsGo(t, "1.25.3")
+	macportsGo = "1.24"
+	if current := currentMacportsGo(); current != "1.24" {
+		t.Errorf("unexpected version with --macports-go: %s", current)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/urfave/cli"
+	"golang.org/x/sync/errgroup"
+)
+
+// An entry of a checksums block as written in a portfile. File is empty
+// when the checksums are given without a distfile name.
+type DeclaredChecksums struct {
+	File      string
+	Checksums Checksums
+}
+
+var checksumKeywords = map[string]bool{
+	"rmd160": true, "sha256": true, "size": true,
+}
+
+// Read the entries of every checksums block in a portfile, in order
+func parseChecksums(portfile string) []DeclaredChecksums {
+	var ret []DeclaredChecksums
+	for _, entry := range parseKeywordBlocks(portfile, checksumsPattern, checksumKeywords) {
+		ret = append(ret, DeclaredChecksums{File: entry.Name, Checksums: entry.checksums()})
+	}
+	return ret
+}
+
+// The URL of the main distfile of a portfile that selects it with
+// master_sites and distname, as written for release assets. Only literal
+// values can be resolved.
+func distfileUrlFromPortfile(portfile string) (string, error) {
+	site, name := distfileFromPortfile(portfile)
+	url := site + name
+	if site == "" || name == "" || strings.Contains(url, "$") {
+		return "", errors.New("Could not resolve the main distfile from master_sites and distname")
+	}
+	return url, nil
+}
+
+// Differences between the declared checksums of a distfile and those of the
+// tarball at url
+func verifyDistfile(label string, url string, declared Checksums) []string {
+	tarball, err := fetchTarball(url, scanChecksums)
+	if err != nil {
+		return []string{fmt.Sprintf("download failed: %s: %s", label, err)}
+	}
+	if tarball.Status != 200 {
+		return []string{fmt.Sprintf("download failed: %s: HTTP status=%d at %s", label, tarball.Status, url)}
+	}
+	var problems []string
+	for _, field := range checksumMismatches(declared, tarball.Checksums) {
+		problems = append(problems, fmt.Sprintf("checksum mismatch: %s %s", label, field))
+	}
+	return problems
+}
+
+func verifyMain(portfilePath string, portfile string) ([]string, error) {
+	declared := parseChecksums(portfile)
+	if len(declared) == 0 {
+		return nil, nil
+	}
+	for _, extra := range declared[1:] {
+		log.Printf("WARNING: Not verifying checksums of %s", extra.File)
+	}
+	var url string
+	var err error
+	if distfileLinesPattern.MatchString(portfile) {
+		url, err = distfileUrlFromPortfile(portfile)
+	} else {
+		var version string
+		version, err = versionFromPortfile(portfile)
+		if err != nil {
+			return nil, err
+		}
+		var pkg Package
+		pkg, err = portfilePackage(portfilePath, portfile, version)
+		if err != nil {
+			return nil, err
+		}
+		pkg.TarballFrom = tarballFromPortfile(portfile)
+		url, err = tarballUrlForMain(pkg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return verifyDistfile("main", url, declared[0].Checksums), nil
+}
+
+func verifyVendors(portfile string) []string {
+	declared := parseGoVendors(portfile)
+	problems := make([][]string, len(declared))
+	// Downloads are limited globally by fetch, so no limit is needed here
+	var g errgroup.Group
+	for i, d := range declared {
+		i, d := i, d
+		g.Go(func() error {
+			repo := d.Repo
+			if repo == "" {
+				repo = d.Id
+			}
+			vendor := resolveVendor(Dependency{Name: repo, Version: d.Lock})
+			if vendor.TarballUrl == "" {
+				problems[i] = []string{fmt.Sprintf("unresolved: %s", d.Id)}
+				return nil
+			}
+			problems[i] = verifyDistfile(d.Id, vendor.TarballUrl, d.Checksums)
+			return nil
+		})
+	}
+	_ = g.Wait()
+	var ret []string
+	for _, p := range problems {
+		ret = append(ret, p...)
+	}
+	return ret
+}
+
+func verifyOne(portname string) ([]string, error) {
+	portfilePath, portfile, err := readPortfile(portname)
+	if err != nil {
+		return nil, err
+	}
+	problems, err := verifyMain(portfilePath, portfile)
+	if err != nil {
+		return nil, err
+	}
+	return append(problems, verifyVendors(portfile)...), nil
+}
+
+func verify(c *cli.Context) error {
+	return forEachPort(c, verifyOne)
+}
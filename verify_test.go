@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseChecksums(t *testing.T) {
+	result := Result{Checksums: Checksums{Rmd160: "r", Sha256: "s", Size: "1"}}
+	portfile := "go.setup            github.com/foo/bar 1.0.0\n\n" + checksumsStr(&result) + "\n\n" +
+		vendorsBlock([]Vendor{{
+			Dependency: Dependency{Name: "github.com/x/a", Version: "v1.0.0"},
+			Package:    Package{Id: "github.com/x/a", ResolvedId: "github.com/x/a", Version: "v1.0.0"},
+			Checksums:  Checksums{Rmd160: "vr", Sha256: "vs", Size: "2"},
+		}}, false) + "\n"
+	expected := []DeclaredChecksums{{Checksums: Checksums{"r", "s", "1"}}}
+	if actual := parseChecksums(portfile); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("unexpected checksums: %+v", actual)
+	}
+
+	named := "checksums           bar-1.0.tar.gz \\\n" +
+		"                    sha256  s1 \\\n" +
+		"                    size    1 \\\n" +
+		"                    extra.zip \\\n" +
+		"                    sha256  s2 \\\n" +
+		"                    size    2\n"
+	expected = []DeclaredChecksums{
+		{File: "bar-1.0.tar.gz", Checksums: Checksums{Sha256: "s1", Size: "1"}},
+		{File: "extra.zip", Checksums: Checksums{Sha256: "s2", Size: "2"}},
+	}
+	if actual := parseChecksums(named); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("unexpected checksums: %+v", actual)
+	}
+}
+
+func TestDistfileUrlFromPortfile(t *testing.T) {
+	portfile := "go.setup            github.com/foo/bar 1.0.0 v\n" +
+		"master_sites        https://github.com/foo/bar/releases/download/v1.0.0/\n" +
+		"distname            bar-1.0.0-vendored\n" +
+		"use_xz              yes\n"
+	url, err := distfileUrlFromPortfile(portfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "https://github.com/foo/bar/releases/download/v1.0.0/bar-1.0.0-vendored.tar.xz" {
+		t.Errorf("unexpected URL: %s", url)
+	}
+
+	portfile = "master_sites        https://example.com/${version}/\n" +
+		"distname            bar\n"
+	if _, err := distfileUrlFromPortfile(portfile); err == nil {
+		t.Error("expected an error for a non-literal master_sites")
+	}
+}